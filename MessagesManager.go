@@ -3,20 +3,62 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"strings"
+	"time"
 )
 
+// memoryVersion is the version of the memory file format written by save.
+// Version 1 files are the raw request body and are upgraded on load.
+const memoryVersion = 2
+
 // Message represents a single message in the conversation.
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string     `json:"role"`
+	Content string     `json:"content"`
+	Time    *time.Time `json:"time,omitempty"`
+	// The fields below are only set on assistant messages.
+	Model        string `json:"model,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+	Usage        *Usage `json:"usage,omitempty"`
+	LatencyMs    int64  `json:"latency_ms,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
 }
 
 // Messages represents a conversation consisting of multiple messages.
 type Messages struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Stream      bool      `json:"stream"`
-	Temperature float32   `json:"temperature"`
+	Version     int        `json:"version"`
+	Title       string     `json:"title,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	Updated     *time.Time `json:"updated,omitempty"`
+	Model       string     `json:"model"`
+	Messages    []Message  `json:"messages"`
+	Stream      bool       `json:"-"`
+	Temperature float32    `json:"temperature"`
+	// Provider is the name of the provider that answered last.
+	Provider string `json:"provider,omitempty"`
+	// Archive keeps messages that were compacted or trimmed away, oldest first.
+	Archive []Message `json:"archive,omitempty"`
+	// Branch is the active branch, empty for the main branch.
+	Branch   string   `json:"branch,omitempty"`
+	Branches []Branch `json:"branches,omitempty"`
+
+	// lastReply describes the answer the next assistant message holds.
+	lastReply *Reply
+}
+
+// RequestMessage is a message as sent to a provider.
+type RequestMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// RequestBody is the chat completions request sent to a provider.
+type RequestBody struct {
+	Model       string           `json:"model"`
+	Messages    []RequestMessage `json:"messages"`
+	Stream      bool             `json:"stream"`
+	Temperature float32          `json:"temperature"`
 }
 
 // NewMessages creates a new Messages object.
@@ -31,9 +73,11 @@ func NewMessages() *Messages {
 
 // AddMessage adds a new message to the conversation.
 func (m *Messages) AddMessage(role, content string) {
+	now := time.Now()
 	message := Message{
 		Role:    role,
 		Content: content,
+		Time:    &now,
 	}
 	m.Messages = append(m.Messages, message)
 }
@@ -46,8 +90,34 @@ func (m *Messages) AddUserMessage(content string) {
 	m.AddMessage("user", content)
 }
 
+// AddAssistantMessage adds an answer, together with the metadata of the
+// request that produced it when there is one.
 func (m *Messages) AddAssistantMessage(content string) {
 	m.AddMessage("assistant", content)
+	if reply := m.lastReply; reply != nil {
+		message := &m.Messages[len(m.Messages)-1]
+		message.Model = reply.Model
+		message.Provider = reply.Provider
+		usage := reply.Usage
+		message.Usage = &usage
+		message.LatencyMs = reply.Latency.Milliseconds()
+		message.FinishReason = reply.FinishReason
+		m.lastReply = nil
+	}
+}
+
+// requestBody returns the part of the conversation sent to a provider.
+func (m *Messages) requestBody() RequestBody {
+	messages := make([]RequestMessage, len(m.Messages))
+	for i, message := range m.Messages {
+		messages[i] = RequestMessage{Role: message.Role, Content: message.Content}
+	}
+	return RequestBody{
+		Model:       m.Model,
+		Messages:    messages,
+		Stream:      m.Stream,
+		Temperature: m.Temperature,
+	}
 }
 
 // Serialize serializes the Messages object to a JSON string.
@@ -60,6 +130,15 @@ func (m *Messages) Serialize() (string, error) {
 }
 
 func (m *Messages) save(path string) error {
+	now := time.Now()
+	m.Version = memoryVersion
+	m.Updated = &now
+	if m.Created == nil {
+		m.Created = &now
+	}
+	if m.Title == "" {
+		m.Title = m.defaultTitle()
+	}
 	// Serialize the Messages object to a JSON string
 	data, err := m.Serialize()
 	if err != nil {
@@ -84,9 +163,38 @@ func (m *Messages) load(path string) error {
 	if err != nil {
 		return err
 	}
+	if m.Version < memoryVersion {
+		m.upgrade(path)
+	}
 	return nil
 }
 
+// upgrade fills in what version 1 files did not record. Message times stay
+// unknown, the file time is the best guess for the session.
+func (m *Messages) upgrade(path string) {
+	if fileInfo, err := os.Stat(path); err == nil {
+		modTime := fileInfo.ModTime()
+		m.Created = &modTime
+		m.Updated = &modTime
+	}
+	m.Title = m.defaultTitle()
+	m.Version = memoryVersion
+}
+
+// defaultTitle is the beginning of the first user message.
+func (m *Messages) defaultTitle() string {
+	for _, message := range m.Messages {
+		if message.Role == "user" {
+			title := []rune(strings.Join(strings.Fields(message.Content), " "))
+			if len(title) > 50 {
+				return string(title[:50]) + "..."
+			}
+			return string(title)
+		}
+	}
+	return ""
+}
+
 // Deserialize deserializes a JSON string into a Messages object.
 func (m *Messages) Deserialize(data string) error {
 	err := json.Unmarshal([]byte(data), m)
@@ -98,14 +206,21 @@ func (m *Messages) Deserialize(data string) error {
 
 func (m *Messages) CloneMessages() *Messages {
 	cloned := NewMessages()
+	cloned.Version = m.Version
+	cloned.Title = m.Title
+	cloned.Tags = append([]string(nil), m.Tags...)
+	cloned.Created = m.Created
+	cloned.Updated = m.Updated
 	cloned.Model = m.Model
 	cloned.Stream = m.Stream
 	cloned.Temperature = m.Temperature
+	cloned.Provider = m.Provider
+	cloned.Archive = append([]Message(nil), m.Archive...)
+	cloned.Branch = m.Branch
+	cloned.Branches = append([]Branch(nil), m.Branches...)
 
 	// 复制每个 Message 对象到克隆对象中
-	for _, msg := range m.Messages {
-		cloned.AddMessage(msg.Role, msg.Content)
-	}
+	cloned.Messages = append(cloned.Messages, m.Messages...)
 
 	return cloned
 }
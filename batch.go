@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Formats of batch files.
+const (
+	batchCSV   = "csv"
+	batchTSV   = "tsv"
+	batchJSONL = "jsonl"
+)
+
+// batchRow is a row of a batch file. Record holds the fields of CSV and TSV
+// rows, Raw the line of JSONL rows.
+type batchRow struct {
+	Line   int
+	Data   map[string]interface{}
+	Record []string
+	Raw    []byte
+}
+
+// batchTable is a batch file read as a whole.
+type batchTable struct {
+	format string
+	header []string
+	rows   []batchRow
+}
+
+// batchFormat guesses the format of a file from its extension.
+func batchFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsv", ".tab":
+		return batchTSV
+	case ".jsonl", ".ndjson":
+		return batchJSONL
+	}
+	return batchCSV
+}
+
+func readBatch(r io.Reader, format string) (*batchTable, error) {
+	table := &batchTable{format: format}
+	if format == batchJSONL {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			var data map[string]interface{}
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			table.rows = append(table.rows, batchRow{Line: line, Data: data, Raw: append([]byte(nil), raw...)})
+		}
+		return table, scanner.Err()
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if format == batchTSV {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the input is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	table.header = header
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		data := map[string]interface{}{}
+		for i, name := range header {
+			if i < len(record) {
+				data[name] = record[i]
+			} else {
+				data[name] = ""
+			}
+		}
+		table.rows = append(table.rows, batchRow{Line: line, Data: data, Record: record})
+	}
+	return table, nil
+}
+
+// columnIndex returns the position of column in the header, appending it
+// when missing.
+func (t *batchTable) columnIndex(column string) int {
+	for i, name := range t.header {
+		if name == column {
+			return i
+		}
+	}
+	t.header = append(t.header, column)
+	return len(t.header) - 1
+}
+
+func (t *batchTable) encodeRecord(record []string) string {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if t.format == batchTSV {
+		writer.Comma = '\t'
+	}
+	writer.Write(record)
+	writer.Flush()
+	return buffer.String()
+}
+
+// encodeRow returns row with answer in column, as written to the output.
+func (t *batchTable) encodeRow(row batchRow, column string, index int, answer string) string {
+	if t.format == batchJSONL {
+		if _, exists := row.Data[column]; !exists {
+			// Append the answer to keep the row as it was written.
+			key, _ := json.Marshal(column)
+			value, _ := json.Marshal(answer)
+			raw := bytes.TrimSpace(bytes.TrimSuffix(row.Raw, []byte("}")))
+			if len(raw) > 1 {
+				raw = append(raw, ',')
+			}
+			return string(raw) + string(key) + ":" + string(value) + "}\n"
+		}
+		data := map[string]interface{}{}
+		for key, value := range row.Data {
+			data[key] = value
+		}
+		data[column] = answer
+		return jsonLine(data)
+	}
+
+	record := make([]string, len(t.header))
+	copy(record, row.Record)
+	record[index] = answer
+	return t.encodeRecord(record)
+}
+
+func batchCommand(configManager *ConfigManager, configData map[string]interface{}, args []string) {
+	var (
+		input      string
+		output     string
+		format     string
+		text       string
+		column     string
+		systemRole string
+		rules      []string
+		parallel   int
+	)
+
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	flags.StringVar(&input, "input", "-", "CSV, TSV or JSONL file to read, - for stdin.")
+	flags.StringVar(&output, "out", "-", "File to write the rows with the answers to, - for stdout.")
+	flags.StringVar(&format, "format", "", "Format of the input and output: csv, tsv or jsonl. Guessed from the input file name by default.")
+	flags.StringVar(&text, "template", "", "Prompt template or file, fields of the row are {{.name}}.")
+	flags.StringVar(&column, "column", "answer", "Column the answers are written to.")
+	flags.StringVar(&systemRole, "system-rule", "", "Rule name, file path or text used as system role.")
+	flags.StringArrayVar(&rules, "rule", nil, "Rule name from the rule library, file path or text, repeat to combine rules.")
+	flags.IntVar(&parallel, "parallel", 1, "Answer up to this many rows at the same time.")
+	flags.BoolVar(&resumeJob, "resume", false, "Continue a batch that failed, skipping the rows already answered.")
+	flags.Usage = func() {
+		fmt.Println("USAGE:")
+		fmt.Println("  tgpt batch --template <template> [option]")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+		fmt.Println("")
+		fmt.Println("EXAMPLES:\n  tgpt batch --input data.csv --template \"Classify: {{.description}}\" --column category > classified.csv\n  tgpt batch --input reviews.jsonl --template review.tmpl --column sentiment --parallel 8 --out reviews.out.jsonl")
+	}
+	flags.Parse(args)
+
+	if text == "" {
+		fmt.Println("no prompt template, use --template")
+		os.Exit(-1)
+	}
+	if parallel < 1 {
+		fmt.Println("--parallel must be at least 1")
+		os.Exit(-1)
+	}
+	prompt, err := template.New("template").Option("missingkey=error").Parse(tryReadContent(text))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	if format == "" {
+		format = batchFormat(input)
+	}
+	if format != batchCSV && format != batchTSV && format != batchJSONL {
+		fmt.Println("unknown batch format:", format)
+		os.Exit(-1)
+	}
+
+	in := os.Stdin
+	if input != "-" {
+		if in, err = os.Open(input); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		defer in.Close()
+	}
+	table, err := readBatch(in, format)
+	if err != nil {
+		fmt.Println("Error reading input:", err)
+		os.Exit(-1)
+	}
+
+	out := os.Stdout
+	if output != "-" {
+		if out, err = os.Create(output); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		defer out.Close()
+	}
+
+	ruleData, _ := ruleVars("", "", nil)
+	messages := NewMessages()
+	messages.AddSystemMessage(buildSystemRule(configData, append([]string{systemRole}, rules...), ruleData))
+
+	index := -1
+	if format != batchJSONL {
+		index = table.columnIndex(column)
+		out.WriteString(table.encodeRecord(table.header))
+	}
+
+	// Rows are sent as tasks of the line mode, the prompt is the task text.
+	tasks := make(chan lineTask)
+	prompts := make([]string, len(table.rows))
+	errs := make([]error, len(table.rows))
+	for i, row := range table.rows {
+		var buffer bytes.Buffer
+		errs[i] = prompt.Execute(&buffer, row.Data)
+		prompts[i] = buffer.String()
+	}
+	go func() {
+		defer close(tasks)
+		for i, row := range table.rows {
+			tasks <- lineTask{Index: i, Number: row.Line, Text: prompts[i]}
+		}
+	}()
+
+	checkpoint, err := openCheckpoint(jobID(append([]string{"batch"}, args...)))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	_, failed := answerLines(out, tasks, parallel, checkpoint.lineAnswerer("row", func(task lineTask) (string, error) {
+		row := table.rows[task.Index]
+		if err := errs[task.Index]; err != nil {
+			return table.encodeRow(row, column, index, ""), err
+		}
+		input := messages.CloneMessages()
+		input.AddUserMessage(getSafeString(task.Text))
+		fitContext(input)
+		reply, err := requestData(input, nil)
+		if err != nil {
+			return table.encodeRow(row, column, index, ""), err
+		}
+		return table.encodeRow(row, column, index, strings.TrimSpace(reply.Text)), nil
+	}))
+	if failed > 0 {
+		checkpoint.Keep()
+		os.Exit(1)
+	}
+	checkpoint.Remove()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const mainBranch = "main"
+
+// Branch is an alternate continuation of a session. The active branch keeps
+// its messages in Messages.Messages, the others keep a full copy so that
+// compaction of one branch never breaks another.
+type Branch struct {
+	Name     string    `json:"name"`
+	Parent   string    `json:"parent,omitempty"`
+	At       int       `json:"at,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// activeBranch returns the name of the branch held in m.Messages.
+func (m *Messages) activeBranch() string {
+	if m.Branch == "" {
+		return mainBranch
+	}
+	return m.Branch
+}
+
+func (m *Messages) findBranch(name string) *Branch {
+	for i := range m.Branches {
+		if m.Branches[i].Name == name {
+			return &m.Branches[i]
+		}
+	}
+	return nil
+}
+
+// stashActive copies the active messages into the branch list.
+func (m *Messages) stashActive() {
+	name := m.activeBranch()
+	branch := m.findBranch(name)
+	if branch == nil {
+		m.Branches = append(m.Branches, Branch{Name: name})
+		branch = &m.Branches[len(m.Branches)-1]
+	}
+	branch.Messages = append([]Message(nil), m.Messages...)
+}
+
+// fork starts the branch name from the first at messages of the active branch
+// and makes it active.
+func (m *Messages) fork(at int, name string) error {
+	if at < 1 || at > len(m.Messages) {
+		return fmt.Errorf("message %d does not exist, the branch has %d messages", at, len(m.Messages))
+	}
+	if name == "" {
+		name = fmt.Sprintf("branch-%d", len(m.Branches)+1)
+		for m.findBranch(name) != nil || name == m.activeBranch() {
+			name += "'"
+		}
+	}
+	if m.findBranch(name) != nil || name == m.activeBranch() {
+		return fmt.Errorf("branch already exists: %s", name)
+	}
+
+	parent := m.activeBranch()
+	m.stashActive()
+	m.Branches = append(m.Branches, Branch{Name: name, Parent: parent, At: at})
+	m.Messages = append([]Message(nil), m.Messages[:at]...)
+	m.Branch = name
+	return nil
+}
+
+// switchBranch makes the branch name active.
+func (m *Messages) switchBranch(name string) error {
+	if name == m.activeBranch() {
+		return nil
+	}
+	if m.findBranch(name) == nil {
+		return fmt.Errorf("unknown branch: %s", name)
+	}
+	m.stashActive()
+	branch := m.findBranch(name)
+	m.Messages = branch.Messages
+	branch.Messages = nil
+	m.Branch = name
+	return nil
+}
+
+// branchTree renders the branches as an indented tree, the active one marked.
+func (m *Messages) branchTree() string {
+	children := map[string][]Branch{}
+	for _, branch := range m.Branches {
+		if branch.Parent != "" {
+			children[branch.Parent] = append(children[branch.Parent], branch)
+		}
+	}
+
+	var tree strings.Builder
+	var walk func(name string, depth int, at int)
+	walk = func(name string, depth int, at int) {
+		count := len(m.Messages)
+		if name != m.activeBranch() {
+			count = len(m.findBranch(name).Messages)
+		}
+		marker := "  "
+		if name == m.activeBranch() {
+			marker = "* "
+		}
+		line := fmt.Sprintf("%s%s%s (%d messages", marker, strings.Repeat("  ", depth), name, count)
+		if at > 0 {
+			line += fmt.Sprintf(", forked at %d", at)
+		}
+		tree.WriteString(line + ")\n")
+		for _, child := range children[name] {
+			walk(child.Name, depth+1, child.At)
+		}
+	}
+
+	// Every session starts on the main branch, it is the root of the tree.
+	walk(mainBranch, 0, 0)
+	return strings.TrimRight(tree.String(), "\n")
+}
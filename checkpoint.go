@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// jobsDir holds the checkpoints of jobs that did not finish.
+var jobsDir string
+
+// resumeJob makes jobs continue from their checkpoint instead of starting
+// over.
+var resumeJob bool
+
+// runningJob is the checkpoint of a job whose requests end the program when
+// they fail, it is kept by keepRunningJob before the program ends.
+var runningJob *Checkpoint
+
+// Checkpoint records the outputs of the finished parts of a job, the chunks
+// of a block or the lines of line mode. It is a file of one JSON entry per
+// finished part, removed once the whole job is done.
+type Checkpoint struct {
+	path    string
+	outputs map[string]string
+	file    *os.File
+	mutex   sync.Mutex
+}
+
+type checkpointEntry struct {
+	Part   string `json:"part"`
+	Output string `json:"output"`
+}
+
+// jobID identifies a job by its command line, without --resume, so that
+// running the same command again finds its checkpoint.
+func jobID(args []string) string {
+	hash := sha256.New()
+	for _, arg := range args {
+		if arg == "--resume" {
+			continue
+		}
+		hash.Write([]byte(arg + "\x00"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// openCheckpoint opens the checkpoint of the job id. The finished parts are
+// loaded with --resume, otherwise the job starts over.
+func openCheckpoint(id string) (*Checkpoint, error) {
+	if err := os.MkdirAll(jobsDir, 0755); err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{path: filepath.Join(jobsDir, id+".jsonl"), outputs: map[string]string{}}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resumeJob {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if file, err := os.Open(checkpoint.path); err == nil {
+			scanner := bufio.NewScanner(file)
+			scanner.Buffer(nil, 64*1024*1024)
+			for scanner.Scan() {
+				var entry checkpointEntry
+				// A line cut short by a crash is skipped, its part is redone.
+				if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+					checkpoint.outputs[entry.Part] = entry.Output
+				}
+			}
+			file.Close()
+		}
+		if len(checkpoint.outputs) > 0 {
+			warnColor.Fprintf(os.Stderr, "resuming, %d parts already done\n", len(checkpoint.outputs))
+		}
+	}
+
+	file, err := os.OpenFile(checkpoint.path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.file = file
+	return checkpoint, nil
+}
+
+// partKey names a part of a job by its position and content, a part whose
+// input changed is not taken from the checkpoint.
+func partKey(kind string, number int, input string) string {
+	hash := sha256.Sum256([]byte(input))
+	return fmt.Sprintf("%s:%d:%s", kind, number, hex.EncodeToString(hash[:8]))
+}
+
+// Output returns the output of a finished part.
+func (c *Checkpoint) Output(part string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	output, ok := c.outputs[part]
+	return output, ok
+}
+
+// Done records the output of a finished part.
+func (c *Checkpoint) Done(part string, output string) error {
+	data, err := json.Marshal(checkpointEntry{Part: part, Output: output})
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.outputs[part] = output
+	_, err = c.file.Write(append(data, '\n'))
+	return err
+}
+
+// Remove deletes the checkpoint of a job that is done.
+func (c *Checkpoint) Remove() {
+	c.file.Close()
+	os.Remove(c.path)
+}
+
+// Keep closes the checkpoint of a job that failed and tells how to resume it.
+func (c *Checkpoint) Keep() {
+	c.file.Close()
+	warnColor.Fprintln(os.Stderr, "progress is saved, run the same command with --resume to continue")
+}
+
+// keepRunningJob keeps the checkpoint of the running job, if any, when a
+// request failed and the program ends.
+func keepRunningJob() {
+	if runningJob != nil {
+		runningJob.Keep()
+	}
+}
+
+// lineAnswerer answers the lines of line mode, kind names them in the
+// checkpoint.
+func (c *Checkpoint) lineAnswerer(kind string, answer func(lineTask) (string, error)) func(lineTask) (string, error) {
+	return func(task lineTask) (string, error) {
+		part := partKey(kind, task.Number, task.Text)
+		if output, ok := c.Output(part); ok {
+			return output, nil
+		}
+		output, err := answer(task)
+		if err == nil {
+			if err := c.Done(part, output); err != nil {
+				warnColor.Fprintln(os.Stderr, "checkpoint:", strings.TrimSpace(err.Error()))
+			}
+		}
+		return output, err
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// chunkSize is the size of the chunks of a block in tokens and chunkOverlap
+// the number of tokens at the end of a chunk repeated at the start of the
+// next one, so that no sentence loses its context.
+var (
+	chunkSize    = 768
+	chunkOverlap = 64
+)
+
+var (
+	paragraphRegex = regexp.MustCompile(`(?s).*?(?:\n[ \t]*\n\s*|$)`)
+	sentenceRegex  = regexp.MustCompile(`(?s).*?(?:[.!?;]+["')\]]*(?:\s+|$)|[。！？；…]+["'”’」』）)]*\s*|\n|$)`)
+)
+
+// splitOn splits text at the ends of the matches of re, the pieces joined
+// are text again.
+func splitOn(re *regexp.Regexp, text string) []string {
+	var pieces []string
+	for _, piece := range re.FindAllString(text, -1) {
+		if piece != "" {
+			pieces = append(pieces, piece)
+		}
+	}
+	return pieces
+}
+
+// Levels at which a piece too big for a chunk is split further.
+const (
+	splitParagraphs = iota
+	splitSentences
+	splitTokens
+	splitRunes
+)
+
+// splitChunks splits text into chunks of at most size tokens. It splits on
+// paragraphs, then on sentences and then on tokens when a sentence is still
+// too long, never inside a character. Chunks after the first start with up
+// to overlap tokens of the end of the previous chunk.
+func splitChunks(text string, size, overlap int) []string {
+	if size < 1 {
+		size = 1
+	}
+	if overlap >= size/2 {
+		overlap = size / 2
+	}
+
+	var chunks []string
+	var current strings.Builder
+	tokens := 0
+	fresh := false
+	// last is the last pre-tokenizer piece of the chunk, a piece added to
+	// the chunk can merge with it into other tokens.
+	last, lastTokens := "", 0
+
+	flush := func() {
+		chunk := current.String()
+		chunks = append(chunks, chunk)
+		current.Reset()
+		tail := tailTokens(chunk, overlap)
+		current.WriteString(tail)
+		tokens, fresh = 0, false
+		last, lastTokens = "", 0
+		if tail != "" {
+			tokens, last = joinTokens("", tail)
+			lastTokens = pieceTokens(last)
+		}
+	}
+
+	var add func(piece string, level int)
+	add = func(piece string, level int) {
+		joined, end := joinTokens(last, piece)
+		n := joined - lastTokens
+		write := func() {
+			current.WriteString(piece)
+			tokens += n
+			last, lastTokens = end, pieceTokens(end)
+			fresh = true
+		}
+		if tokens+n <= size {
+			write()
+			return
+		}
+		if fresh {
+			flush()
+			add(piece, level)
+			return
+		}
+
+		// The piece does not fit into an empty chunk, split it further.
+		var pieces []string
+		switch level {
+		case splitParagraphs:
+			pieces = splitOn(sentenceRegex, piece)
+		case splitSentences:
+			pieces = pieceRegex.FindAllString(piece, -1)
+		default:
+			pieces = splitRunesBy(piece, size-tokens, countTokens(piece))
+		}
+		if len(pieces) <= 1 {
+			if level < splitRunes {
+				add(piece, level+1)
+				return
+			}
+			// A single character is too big for a chunk, it is kept whole.
+			write()
+			return
+		}
+		// Parts of characters are cut again until they fit.
+		next := level + 1
+		if next > splitRunes {
+			next = splitRunes
+		}
+		for _, p := range pieces {
+			add(p, next)
+		}
+	}
+
+	for _, paragraph := range splitOn(paragraphRegex, text) {
+		add(paragraph, splitParagraphs)
+	}
+	if fresh {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// joinTokens returns the tokens of piece appended to last and the last
+// pre-tokenizer piece of the two. Only the last piece of a text merges with
+// what is appended to it.
+func joinTokens(last, piece string) (int, string) {
+	tokens, end := 0, ""
+	for _, p := range pieceRegex.FindAllString(last+piece, -1) {
+		tokens += pieceTokens(p)
+		end = p
+	}
+	return tokens, end
+}
+
+// splitRunesBy cuts a piece of n tokens into parts of about room tokens.
+func splitRunesBy(piece string, room, n int) []string {
+	runes := []rune(piece)
+	if room < 1 {
+		room = 1
+	}
+	step := len(runes) * room / n
+	if step < 1 {
+		step = 1
+	}
+	var parts []string
+	for i := 0; i < len(runes); i += step {
+		end := i + step
+		if end > len(runes) {
+			end = len(runes)
+		}
+		parts = append(parts, string(runes[i:end]))
+	}
+	return parts
+}
+
+// tailTokens returns the end of text of at most n tokens.
+func tailTokens(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	pieces := pieceRegex.FindAllString(text, -1)
+	tokens, start := 0, len(pieces)
+	for start > 0 {
+		t := pieceTokens(pieces[start-1])
+		if tokens+t > n {
+			break
+		}
+		tokens += t
+		start--
+	}
+	// Long runs such as CJK text without spaces are a single piece, take
+	// as many of its last characters as still fit.
+	partial := ""
+	if start > 0 {
+		runes := []rune(pieces[start-1])
+		for i := len(runes) - 1; i > 0 && pieceTokens(string(runes[i:])) <= n-tokens; i-- {
+			partial = string(runes[i:])
+		}
+	}
+	return strings.TrimLeft(partial+strings.Join(pieces[start:], ""), " \t\r\n")
+}
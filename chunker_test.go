@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var chunkerTexts = map[string]string{
+	"empty":      "",
+	"short":      "A single sentence.",
+	"paragraphs": strings.Repeat("The quick brown fox jumps over the lazy dog. It was not amused, was it? No!\n\n", 40),
+	"one line":   strings.Repeat("word, another word; and then some more words ", 200),
+	"long word":  strings.Repeat("a", 5000),
+	"chinese":    strings.Repeat("今天天气很好。我们去公园散步吧！你觉得怎么样？", 80),
+	"japanese":   strings.Repeat("これは日本語の文章です。", 100) + "\n\n" + strings.Repeat("カタカナ", 300),
+	"mixed":      strings.Repeat("Go 语言的 goroutine 很轻量。Channels connect them.\n", 60),
+	"code":       strings.Repeat("func main() {\n\tfmt.Println(\"hello, world\")\n}\n\n", 50),
+	"spaces":     "  \n\n\t\n " + strings.Repeat("x ", 500) + "\n\n\n",
+}
+
+func TestSplitChunks(t *testing.T) {
+	for name, text := range chunkerTexts {
+		for _, size := range []int{1, 8, 64, 768} {
+			for _, overlap := range []int{0, 4, 64} {
+				chunks := splitChunks(text, size, overlap)
+				for i, chunk := range chunks {
+					if !utf8.ValidString(chunk) {
+						t.Errorf("%s, size %d, overlap %d: chunk %d is not valid UTF-8", name, size, overlap, i)
+					}
+					if n := countTokens(chunk); n > size && utf8.RuneCountInString(chunk) > 1 {
+						t.Errorf("%s, size %d, overlap %d: chunk %d has %d tokens", name, size, overlap, i, n)
+					}
+				}
+				if overlap == 0 && strings.Join(chunks, "") != text {
+					t.Errorf("%s, size %d: chunks do not rejoin to the text", name, size)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitChunksOverlap(t *testing.T) {
+	for name, text := range chunkerTexts {
+		chunks := splitChunks(text, 64, 16)
+		for i := 1; i < len(chunks); i++ {
+			tail := tailTokens(chunks[i-1], 16)
+			if !strings.HasPrefix(chunks[i], tail) {
+				t.Errorf("%s: chunk %d does not start with the end of chunk %d %q", name, i, i-1, tail)
+			}
+		}
+	}
+}
+
+func TestSplitChunksBoundaries(t *testing.T) {
+	text := "First paragraph, one sentence.\n\nSecond paragraph. It has two sentences.\n\n"
+	chunks := splitChunks(text, 10, 0)
+	want := []string{"First paragraph, one sentence.\n\n", "Second paragraph. ", "It has two sentences.\n\n"}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("splitChunks = %q, want %q", chunks, want)
+	}
+
+	chunks = splitChunks("今天天气很好。我们去公园散步吧！", 12, 0)
+	want = []string{"今天天气很好。", "我们去公园散步吧！"}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("splitChunks = %q, want %q", chunks, want)
+	}
+}
+
+func TestTailTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"one two three four", 0, ""},
+		{"one two three four", 2, "three four"},
+		{"one two three four", 100, "one two three four"},
+		{"line one\nline two", 2, "line two"},
+		{"今天天气很好", 2, "很好"},
+	}
+	for _, test := range tests {
+		if got := tailTokens(test.text, test.n); got != test.want {
+			t.Errorf("tailTokens(%q, %d) = %q, want %q", test.text, test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// codeLang enables code-only output, "*" keeps blocks of every language.
+// codeOut is the directory code blocks are written to instead of stdout.
+var (
+	codeLang string
+	codeOut  string
+)
+
+// codeRuleHint asks for fenced blocks that can be extracted reliably.
+const codeRuleHint = "Put all code in fenced code blocks tagged with the language. " +
+	"When the code belongs in a file, follow the language with the file name, for example ```go main.go"
+
+// CodeBlock is a fenced code block of a reply.
+type CodeBlock struct {
+	Lang     string
+	Filename string
+	Code     string
+}
+
+var (
+	blockStartRegex = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([^\\s`]*)\\s*(\\S*)")
+	// Lines naming the file of the following block, such as **main.go**,
+	// `main.go`, main.go: or File: main.go.
+	filenameLineRegex = regexp.MustCompile("^\\s*(?:#+\\s*)?(?:(?i:file(?:name)?)\\s*:\\s*)?[*`_]*([\\w./-]+\\.\\w+)[*`_]*\\s*:?\\s*$")
+	// A first line comment naming the file, such as // main.go or # file: app.py.
+	filenameCommentRegex = regexp.MustCompile(`^\s*(?://|#|--|/\*)\s*(?:(?i:file(?:name)?)\s*:\s*)?([\w./-]+\.\w+)\s*(?:\*/)?\s*$`)
+)
+
+// extractCodeBlocks returns the fenced code blocks of text in order.
+func extractCodeBlocks(text string) []CodeBlock {
+	var blocks []CodeBlock
+	var current *CodeBlock
+	var fence string
+	var code []string
+	previous := ""
+
+	for _, line := range strings.Split(text, "\n") {
+		if current != nil {
+			if strings.HasPrefix(strings.TrimSpace(line), fence) {
+				current.Code = strings.Join(code, "\n")
+				if current.Filename == "" && len(code) > 0 {
+					if match := filenameCommentRegex.FindStringSubmatch(code[0]); match != nil {
+						current.Filename = match[1]
+					}
+				}
+				blocks = append(blocks, *current)
+				current, code, previous = nil, nil, ""
+			} else {
+				code = append(code, line)
+			}
+			continue
+		}
+
+		if match := blockStartRegex.FindStringSubmatch(line); match != nil {
+			fence = match[1]
+			current = &CodeBlock{Lang: strings.ToLower(match[2]), Filename: match[3]}
+			// ```main.go names the file without a language.
+			if current.Filename == "" && strings.Contains(current.Lang, ".") {
+				current.Filename = match[2]
+				current.Lang = strings.TrimPrefix(filepath.Ext(match[2]), ".")
+			}
+			if current.Filename == "" {
+				if match := filenameLineRegex.FindStringSubmatch(previous); match != nil {
+					current.Filename = match[1]
+				}
+			}
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			previous = line
+		}
+	}
+
+	// An unterminated block at the end of a reply still counts.
+	if current != nil && len(code) > 0 {
+		current.Code = strings.Join(code, "\n")
+		blocks = append(blocks, *current)
+	}
+	return blocks
+}
+
+// matchesLang reports whether a block tagged lang was asked for with want.
+func matchesLang(lang, want string) bool {
+	if want == "*" || want == "" {
+		return true
+	}
+	normalize := func(l string) string {
+		if alias, ok := languageAliases[l]; ok {
+			return alias
+		}
+		return l
+	}
+	return normalize(strings.ToLower(want)) == normalize(lang)
+}
+
+var languageExtensions = map[string]string{
+	"go": "go", "python": "py", "javascript": "js", "typescript": "ts", "java": "java", "c": "c", "cpp": "cpp",
+	"rust": "rs", "shell": "sh", "sql": "sql", "yaml": "yaml", "json": "json", "html": "html", "css": "css",
+	"markdown": "md", "md": "md", "ruby": "rb", "php": "php", "swift": "swift", "kotlin": "kt", "dockerfile": "Dockerfile",
+	"toml": "toml", "xml": "xml", "lua": "lua", "r": "r", "makefile": "Makefile", "powershell": "ps1",
+}
+
+// isLanguage reports whether name is a language code blocks can be tagged
+// with.
+func isLanguage(name string) bool {
+	name = strings.ToLower(name)
+	_, known := languageExtensions[name]
+	_, alias := languageAliases[name]
+	_, highlighted := codeLanguages[name]
+	return known || alias || highlighted
+}
+
+// codeArgs joins --code and a language following it into --code=lang, the
+// flag takes its value only after = as it may be given without one.
+func codeArgs(args []string) []string {
+	joined := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			return append(joined, args[i:]...)
+		}
+		if args[i] == "--code" && i+1 < len(args) && isLanguage(args[i+1]) {
+			joined = append(joined, "--code="+args[i+1])
+			i++
+			continue
+		}
+		joined = append(joined, args[i])
+	}
+	return joined
+}
+
+// blockFilename returns the file name a block is written to.
+func blockFilename(block CodeBlock, index int) string {
+	if block.Filename != "" {
+		return filepath.Clean(block.Filename)
+	}
+	lang := block.Lang
+	if alias, ok := languageAliases[lang]; ok {
+		lang = alias
+	}
+	ext, ok := languageExtensions[lang]
+	if !ok {
+		ext = "txt"
+	}
+	if ext == "Dockerfile" || ext == "Makefile" {
+		return ext
+	}
+	return fmt.Sprintf("block-%d.%s", index+1, ext)
+}
+
+// selectCode returns the code blocks of a reply in the language of codeLang.
+// A reply without such blocks is taken as code as a whole.
+func selectCode(reply string) []CodeBlock {
+	var blocks []CodeBlock
+	for _, block := range extractCodeBlocks(reply) {
+		if matchesLang(block.Lang, codeLang) {
+			blocks = append(blocks, block)
+		}
+	}
+	if len(blocks) == 0 {
+		warnColor.Fprintln(os.Stderr, "no matching code blocks, using the whole reply")
+		blocks = []CodeBlock{{Lang: strings.TrimPrefix(codeLang, "*"), Code: strings.TrimSpace(reply)}}
+	}
+	return blocks
+}
+
+// codeText returns the code of a reply as printed by --code.
+func codeText(reply string) string {
+	var codes []string
+	for _, block := range selectCode(reply) {
+		codes = append(codes, strings.TrimRight(block.Code, "\n"))
+	}
+	return strings.Join(codes, "\n\n") + "\n"
+}
+
+// printCode prints or writes the code blocks of a reply.
+func printCode(reply string) error {
+	if codeOut == "" {
+		fmt.Print(codeText(reply))
+		return nil
+	}
+
+	blocks := selectCode(reply)
+
+	written := map[string]bool{}
+	for i, block := range blocks {
+		name := blockFilename(block, i)
+		// Keep files inside the output directory.
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			name = filepath.Base(name)
+		}
+		path := filepath.Join(codeOut, name)
+		if written[path] {
+			path = filepath.Join(codeOut, fmt.Sprintf("%d-%s", i+1, name))
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(strings.TrimRight(block.Code, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+		written[path] = true
+		fmt.Println(path)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	flag "github.com/spf13/pflag"
+)
+
+func TestCodeArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		lang string
+		rest []string
+	}{
+		{[]string{"--code", "go", "read a file"}, "go", []string{"read a file"}},
+		{[]string{"--code", "Python", "-q", "read a file"}, "Python", []string{"read a file"}},
+		{[]string{"--code", "golang", "read a file"}, "golang", []string{"read a file"}},
+		{[]string{"--code=go", "read a file"}, "go", []string{"read a file"}},
+		{[]string{"--code", "read a file"}, "*", []string{"read a file"}},
+		{[]string{"read a file", "--code"}, "*", []string{"read a file"}},
+		{[]string{"-q", "read a file"}, "", []string{"read a file"}},
+		{[]string{"--code", "--", "go"}, "*", []string{"go"}},
+	}
+	for _, test := range tests {
+		var lang string
+		flags := flag.NewFlagSet("tgpt", flag.ContinueOnError)
+		flags.StringVar(&lang, "code", "", "")
+		flags.Lookup("code").NoOptDefVal = "*"
+		flags.BoolP("quiet", "q", false, "")
+		if err := flags.Parse(codeArgs(test.args)); err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+		if lang != test.lang || strings.Join(flags.Args(), "|") != strings.Join(test.rest, "|") {
+			t.Errorf("%q: code %q, args %q, want %q and %q", test.args, lang, flags.Args(), test.lang, test.rest)
+		}
+	}
+}
+
+func TestExtractCodeBlocks(t *testing.T) {
+	reply := "Here:\n```go main.go\npackage main\n```\n**util.py**\n```python\nprint(1)\n```\n```\nplain\n```\n"
+	blocks := extractCodeBlocks(reply)
+	want := []CodeBlock{
+		{Lang: "go", Filename: "main.go", Code: "package main"},
+		{Lang: "python", Filename: "util.py", Code: "print(1)"},
+		{Lang: "", Filename: "", Code: "plain"},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("extractCodeBlocks = %+v, want %+v", blocks, want)
+	}
+	for i := range want {
+		if blocks[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, blocks[i], want[i])
+		}
+	}
+}
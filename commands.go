@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// chatSession is the state of interactive mode.
+type chatSession struct {
+	messages   *Messages
+	memory     string
+	name       string
+	configData map[string]interface{}
+}
+
+// errExit is returned by commands that end interactive mode.
+var errExit = errors.New("exit")
+
+// ask sends input as the next user message and prints the answer.
+func (s *chatSession) ask(input string) {
+	s.messages.AddUserMessage(getSafeString(input))
+	s.answer()
+}
+
+// answer requests an answer to the conversation as it is.
+func (s *chatSession) answer() {
+	if outputFormat == outputNDJSON {
+		assistantMessage, err := getNDJSON(s.messages, s.memory)
+		if err != nil {
+			return
+		}
+		s.messages.AddAssistantMessage(getSafeString(assistantMessage))
+		s.save()
+		return
+	}
+
+	if s.name != "" {
+		bold.Print(s.name + ":")
+	} else {
+		bold.Print("AI:")
+	}
+
+	printReply, flushReply := replyPrinter()
+	if renderMarkdown {
+		fmt.Print("\n")
+	}
+	assistantMessage := getData(s.messages, printReply)
+	flushReply()
+	s.messages.AddAssistantMessage(getSafeString(assistantMessage))
+
+	fmt.Print("\n\n")
+	s.save()
+}
+
+func (s *chatSession) save() {
+	if s.memory != "" {
+		saveMemory(s.messages, s.memory)
+	}
+}
+
+// lastUser returns the index of the last user message, -1 when there is none.
+func (s *chatSession) lastUser() int {
+	for i := len(s.messages.Messages) - 1; i >= 0; i-- {
+		if s.messages.Messages[i].Role == "user" {
+			return i
+		}
+	}
+	return -1
+}
+
+type slashCommand struct {
+	args string
+	help string
+	// minArgs and maxArgs bound the number of arguments, -1 reads the
+	// rest of the line as one argument.
+	minArgs, maxArgs int
+	run              func(s *chatSession, args []string) error
+}
+
+var slashCommands map[string]slashCommand
+
+func init() {
+	slashCommands = map[string]slashCommand{
+		"help": {"", "Show the commands.", 0, 0, func(s *chatSession, args []string) error {
+			names := make([]string, 0, len(slashCommands))
+			for name := range slashCommands {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			table := [][]string{{"COMMAND", "DESCRIPTION"}}
+			for _, name := range names {
+				command := slashCommands[name]
+				table = append(table, []string{strings.TrimSpace("/" + name + " " + command.args), command.help})
+			}
+			printTable(table)
+			return nil
+		}},
+		"exit": {"", "Quit interactive mode.", 0, 0, func(s *chatSession, args []string) error {
+			return errExit
+		}},
+		"save": {"[name]", "Save the conversation, to the session name when given.", 0, 1, func(s *chatSession, args []string) error {
+			if len(args) == 1 {
+				s.memory = resolveMemory(args[0])
+			}
+			if s.memory == "" {
+				return errors.New("no session yet, use /save name")
+			}
+			if err := saveMemory(s.messages, s.memory); err != nil {
+				return err
+			}
+			bold.Println("Saved to " + s.memory)
+			return nil
+		}},
+		"load": {"<name>", "Continue the session name.", 1, 1, func(s *chatSession, args []string) error {
+			memory := resolveMemory(args[0])
+			messages := NewMessages()
+			if err := messages.load(memory); err != nil {
+				return err
+			}
+			s.messages, s.memory = messages, memory
+			printMessages(messages.Messages)
+			return nil
+		}},
+		"clear": {"", "Forget the conversation, system messages are kept.", 0, 0, func(s *chatSession, args []string) error {
+			var messages []Message
+			for _, message := range s.messages.Messages {
+				if message.Role == "system" {
+					messages = append(messages, message)
+				}
+			}
+			s.messages.Messages = messages
+			s.save()
+			return nil
+		}},
+		"system": {"<rule>", "Replace the system rule with a rule name, file or text.", 1, -1, func(s *chatSession, args []string) error {
+			var messages []Message
+			for _, message := range s.messages.Messages {
+				if message.Role != "system" || isSummary(message) {
+					messages = append(messages, message)
+				}
+			}
+			s.messages.Messages = messages
+			rule := strings.TrimSpace(renderRule(resolveRule(s.configData, args[0]), systemVars))
+			s.messages.Messages = append([]Message{{Role: "system", Content: rule}}, s.messages.Messages...)
+			s.save()
+			return nil
+		}},
+		"model": {"[model]", "Show or change the model.", 0, 1, func(s *chatSession, args []string) error {
+			if len(args) == 1 {
+				s.messages.Model = args[0]
+				s.save()
+			}
+			fmt.Println(s.messages.Model)
+			return nil
+		}},
+		"temp": {"[temperature]", "Show or change the temperature, between 0 and 2.", 0, 1, func(s *chatSession, args []string) error {
+			if len(args) == 1 {
+				temperature, err := strconv.ParseFloat(args[0], 32)
+				if err != nil || temperature < 0 || temperature > 2 {
+					return fmt.Errorf("temperature must be between 0 and 2: %s", args[0])
+				}
+				s.messages.Temperature = float32(temperature)
+				s.save()
+			}
+			fmt.Println(s.messages.Temperature)
+			return nil
+		}},
+		"undo": {"", "Remove the last question and its answer.", 0, 0, func(s *chatSession, args []string) error {
+			last := s.lastUser()
+			if last < 0 {
+				return errors.New("nothing to undo")
+			}
+			s.messages.Messages = s.messages.Messages[:last]
+			s.save()
+			return nil
+		}},
+		"retry": {"", "Answer the last question again.", 0, 0, func(s *chatSession, args []string) error {
+			last := s.lastUser()
+			if last < 0 {
+				return errors.New("nothing to retry")
+			}
+			s.messages.Messages = s.messages.Messages[:last+1]
+			s.answer()
+			return nil
+		}},
+		"edit": {"", "Write the next message in $EDITOR, prefilled with the last question.", 0, 0, func(s *chatSession, args []string) error {
+			input, err := editMessage(lastUserMessage(s.messages))
+			if err != nil {
+				return err
+			}
+			s.ask(input)
+			return nil
+		}},
+		"history": {"", "Print the conversation.", 0, 0, func(s *chatSession, args []string) error {
+			printMessages(s.messages.Messages)
+			return nil
+		}},
+		"copy-last": {"<file>", "Write the last answer to a file.", 1, -1, func(s *chatSession, args []string) error {
+			for i := len(s.messages.Messages) - 1; i >= 0; i-- {
+				if message := s.messages.Messages[i]; message.Role == "assistant" {
+					return os.WriteFile(args[0], []byte(fromSafeString(message.Content)+"\n"), 0644)
+				}
+			}
+			return errors.New("no answer yet")
+		}},
+		"tokens": {"", "Count the tokens of the conversation.", 0, 0, func(s *chatSession, args []string) error {
+			size := contextSize(s.messages.Model)
+			used := countMessagesTokens(s.messages.Messages)
+			fmt.Printf("%d of %d tokens used by %d messages (%s)\n", used, size, len(s.messages.Messages), s.messages.Model)
+			return nil
+		}},
+		"fork": {"<message> [branch]", "Continue from a message on a new branch.", 1, 2, func(s *chatSession, args []string) error {
+			at, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("not a message number: %s", args[0])
+			}
+			name := ""
+			if len(args) == 2 {
+				name = args[1]
+			}
+			if err := s.messages.fork(at, name); err != nil {
+				return err
+			}
+			bold.Println("Switched to branch " + s.messages.activeBranch())
+			s.save()
+			return nil
+		}},
+		"branches": {"", "Show the branches of the session.", 0, 0, func(s *chatSession, args []string) error {
+			fmt.Println(s.messages.branchTree())
+			return nil
+		}},
+		"switch": {"<branch>", "Switch to another branch.", 1, 1, func(s *chatSession, args []string) error {
+			if err := s.messages.switchBranch(args[0]); err != nil {
+				return err
+			}
+			bold.Println("Switched to branch " + s.messages.activeBranch())
+			printMessages(s.messages.Messages)
+			s.save()
+			return nil
+		}},
+	}
+}
+
+// runCommand runs a slash command typed in interactive mode. It returns
+// errExit when interactive mode should end.
+func (s *chatSession) runCommand(input string) error {
+	line := strings.TrimSpace(strings.TrimPrefix(input, "/"))
+	name, rest, _ := strings.Cut(line, " ")
+	command, ok := slashCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command: /%s, type /help for the commands", name)
+	}
+
+	var args []string
+	rest = strings.TrimSpace(rest)
+	if command.maxArgs < 0 {
+		if rest != "" {
+			args = []string{rest}
+		}
+	} else {
+		args = strings.Fields(rest)
+	}
+	if len(args) < command.minArgs || (command.maxArgs >= 0 && len(args) > command.maxArgs) {
+		return fmt.Errorf("usage: /%s %s", name, command.args)
+	}
+	return command.run(s, args)
+}
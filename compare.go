@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// compareColumn collects the streamed reply of one model.
+type compareColumn struct {
+	label string
+	text  strings.Builder
+	reply *Reply
+	err   error
+}
+
+func compareCommand(configManager *ConfigManager, configData map[string]interface{}, args []string) {
+	var (
+		models     []string
+		systemRole string
+		quiet      bool
+	)
+
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.StringSliceVar(&models, "models", nil, "Comma separated models or providers to compare.")
+	flags.StringVar(&systemRole, "system-rule", "", "Rule name, file path or text used as system role.")
+	flags.BoolVarP(&quiet, "quiet", "q", false, "Print the replies only when all models have finished.")
+	flags.Usage = func() {
+		fmt.Println("USAGE:")
+		fmt.Println("  tgpt compare --models <model,model,...> [option] <prompt|stdin>")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		flags.PrintDefaults()
+		fmt.Println("")
+		fmt.Println("EXAMPLES:\n  tgpt compare --models gpt-3.5-turbo,gpt-4 \"What is internet?\"\n  tgpt compare --models default,backup \"What is internet?\"")
+	}
+	flags.Parse(args)
+
+	if len(models) == 0 {
+		fmt.Println("no models to compare, use --models")
+		os.Exit(-1)
+	}
+
+	prompt := strings.TrimSpace(strings.Join(flags.Args(), " "))
+	if prompt == "" && hasDataInStdin() {
+		bytes, _ := io.ReadAll(os.Stdin)
+		prompt = strings.TrimSpace(string(bytes))
+	}
+	if prompt == "" {
+		fmt.Println("parameter len error:0")
+		os.Exit(-1)
+	}
+
+	messages := NewMessages()
+	ruleData, _ := ruleVars("", "", nil)
+	messages.AddSystemMessage(buildSystemRule(configData, []string{systemRole}, ruleData))
+	messages.AddUserMessage(getSafeString(prompt))
+
+	columns := make([]*compareColumn, len(models))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i, model := range models {
+		column := &compareColumn{label: strings.TrimSpace(model)}
+		columns[i] = column
+
+		// A provider name pins the request to that provider, anything else
+		// is a model sent along the usual chain.
+		chain := providerChain
+		cMessages := messages.CloneMessages()
+		if provider, ok := findProvider(column.label); ok {
+			chain = []Provider{provider}
+		} else {
+			cMessages.Model = column.label
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, err := requestChain(chain, cMessages, func(s string) {
+				mutex.Lock()
+				column.text.WriteString(s)
+				mutex.Unlock()
+			})
+			mutex.Lock()
+			column.reply, column.err = reply, err
+			mutex.Unlock()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	width, height := terminalSize()
+	printed := 0
+	live := !quiet && isTerminal(os.Stdout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case <-ticker.C:
+			if !live {
+				continue
+			}
+			mutex.Lock()
+			lines := renderColumns(columns, width, height-4)
+			mutex.Unlock()
+			clearLines(printed)
+			fmt.Print(strings.Join(lines, "\n") + "\n")
+			printed = len(lines)
+		}
+	}
+
+	clearLines(printed)
+	fmt.Println(strings.Join(renderColumns(columns, width, 0), "\n"))
+	fmt.Println("")
+	printCompareStats(columns)
+}
+
+// renderColumns lays the replies out side by side. When maxRows is positive
+// only the latest maxRows lines of every column are kept.
+func renderColumns(columns []*compareColumn, width int, maxRows int) []string {
+	const separator = " │ "
+	// Leave the last cell free so the terminal never wraps a line by itself.
+	columnWidth := (width - 1 - len([]rune(separator))*(len(columns)-1)) / len(columns)
+	if columnWidth < 8 {
+		columnWidth = 8
+	}
+
+	header := make([]string, len(columns))
+	rule := make([]string, len(columns))
+	bodies := make([][]string, len(columns))
+	rows := 0
+	for i, column := range columns {
+		header[i] = padRight(truncateLabel(column.label, columnWidth), columnWidth)
+		rule[i] = strings.Repeat("─", columnWidth)
+
+		text := strings.TrimSpace(column.text.String())
+		if column.err != nil {
+			text += "\nerror: " + column.err.Error()
+		}
+		body := wrapText(text, columnWidth)
+		if maxRows > 0 && len(body) > maxRows {
+			body = body[len(body)-maxRows:]
+		}
+		bodies[i] = body
+		if len(body) > rows {
+			rows = len(body)
+		}
+	}
+
+	lines := []string{bold.Sprint(strings.Join(header, separator)), strings.Join(rule, "─┼─")}
+	for row := 0; row < rows; row++ {
+		cells := make([]string, len(columns))
+		for i, body := range bodies {
+			cell := ""
+			if row < len(body) {
+				cell = body[row]
+			}
+			cells[i] = padRight(cell, columnWidth)
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, separator), " "))
+	}
+	return lines
+}
+
+func truncateLabel(label string, width int) string {
+	lines := wrapText(label, width)
+	if len(lines) == 0 {
+		return ""
+	}
+	return lines[0]
+}
+
+// clearLines moves the cursor up over the last n printed lines and erases them.
+func clearLines(n int) {
+	if n > 0 {
+		fmt.Printf("\033[%dA\033[J", n)
+	}
+}
+
+func printCompareStats(columns []*compareColumn) {
+	table := [][]string{{"MODEL", "LATENCY", "FIRST TOKEN", "PROMPT", "COMPLETION", "FINISH"}}
+	for _, column := range columns {
+		if column.reply == nil {
+			table = append(table, []string{column.label, "-", "-", "-", "-", "error"})
+			continue
+		}
+		reply := column.reply
+		finish := reply.FinishReason
+		if finish == "" {
+			finish = "-"
+		}
+		table = append(table, []string{
+			column.label,
+			reply.Latency.Round(time.Millisecond).String(),
+			reply.FirstToken.Round(time.Millisecond).String(),
+			usageCount(reply.Usage, reply.Usage.PromptTokens),
+			usageCount(reply.Usage, reply.Usage.CompletionTokens),
+			finish,
+		})
+	}
+
+	printTable(table)
+}
+
+// usageCount formats a token count of usage, estimated counts start with ~.
+func usageCount(usage Usage, tokens int) string {
+	if usage.Estimated {
+		return "~" + fmt.Sprint(tokens)
+	}
+	return fmt.Sprint(tokens)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// editorScissors separates the prompt from the help text in the editor.
+const editorScissors = "# ------------------------ >8 ------------------------"
+
+// editMessage opens the user's editor on initial and returns the saved text
+// above the scissors line.
+func editMessage(initial string) (string, error) {
+	file, err := os.CreateTemp("", "tgpt-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	template := strings.TrimRight(initial, "\n") + "\n\n" + editorScissors + "\n" +
+		"# Write the prompt above this line, everything below it is ignored.\n" +
+		"# Save and close the editor to send it, leave it empty to cancel.\n"
+	_, err = file.WriteString(template)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	// The editor may be a command with arguments such as "code --wait".
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if !isTerminal(os.Stdin) {
+		tty, err := openTTY()
+		if err != nil {
+			return "", err
+		}
+		defer tty.Close()
+		cmd.Stdin, cmd.Stdout = tty, tty
+	}
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	text := string(content)
+	if i := strings.Index(text, editorScissors); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("empty prompt, nothing sent")
+	}
+	return text, nil
+}
+
+// lastUserMessage returns the text of the last user message.
+func lastUserMessage(messages *Messages) string {
+	for i := len(messages.Messages) - 1; i >= 0; i-- {
+		if messages.Messages[i].Role == "user" {
+			return fromSafeString(messages.Messages[i].Content)
+		}
+	}
+	return ""
+}
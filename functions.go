@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
@@ -44,64 +45,107 @@ func newClient() (tls_client.HttpClient, error) {
 	return tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
 }
 
+// Usage holds the token accounting of one completion.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	// Estimated is set when the provider did not report the usage and it
+	// was counted offline.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// Reply is the result of one chat completion request.
+type Reply struct {
+	Provider     string
+	Text         string
+	Model        string
+	FinishReason string
+	Usage        Usage
+	Latency      time.Duration
+	FirstToken   time.Duration
+}
+
 func getData(input *Messages, callback func(string)) (fullText string) {
+	fitContext(input)
+	reply, err := requestData(input, callback)
+	if err != nil {
+		bold.Println("\rSome error has occurred.")
+		fmt.Println("\nError:", err)
+		// A job that can be resumed failed, scripts have to know.
+		if runningJob != nil {
+			keepRunningJob()
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	input.Provider = reply.Provider
+	input.lastReply = reply
+	return reply.Text
+}
+
+// requestData sends the conversation along the provider chain and streams the
+// answer through callback. Unlike getData it reports failures to the caller.
+func requestData(input *Messages, callback func(string)) (*Reply, error) {
+	return requestChain(providerChain, input, callback)
+}
+
+// requestProvider sends the conversation to a single provider.
+func requestProvider(provider Provider, input *Messages, callback func(string)) (*Reply, error) {
 
 	client, err := newClient()
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
 	}
 
-	safeInput, _ := json.Marshal(input)
+	body := input.requestBody()
+	if provider.Model != "" {
+		body.Model = provider.Model
+	}
+	safeInput, _ := json.Marshal(body)
 	//fmt.Println(string(safeInput))
 
 	var data = strings.NewReader(string(safeInput))
 
-	req, err := http.NewRequest("POST", "https://gpt.s-stars.top/v1/chat/completions", data)
+	req, err := http.NewRequest("POST", provider.URL, data)
 	if err != nil {
-		fmt.Println("\nSome error has occurred.")
-		fmt.Println("Error:", err)
-		os.Exit(0)
+		return nil, err
+	}
+	authKey := provider.AuthKey
+	if authKey == "" {
+		authKey = string(AUTH_KEY)
 	}
 	// Setting all the required headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", string(AUTH_KEY))
+	req.Header.Set("Authorization", authKey)
+
+	start := time.Now()
+	reply := &Reply{Provider: provider.Name, Model: body.Model}
 
 	// Receiving response
 	resp, err := client.Do(req)
-
 	if err != nil {
-
-		bold.Println("\rSome error has occurred. Check your internet connection.")
-		fmt.Println("\nError:", err)
-		os.Exit(0)
+		return nil, fmt.Errorf("check your internet connection: %w", err)
 	}
-	code := resp.StatusCode
+	defer resp.Body.Close()
 
-	if code >= 400 {
-		bold.Println("\rSome error has occurred. Please try again")
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			fmt.Print(scanner.Text())
-		}
-		os.Exit(0)
+	if code := resp.StatusCode; code >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &statusError{code: code, body: strings.TrimSpace(string(body))}
 	}
 
-	defer resp.Body.Close()
-
 	scanner := bufio.NewScanner(resp.Body)
 
-	if err != nil {
-		fmt.Println("Error occurred getting terminal width. Error:", err)
-		os.Exit(0)
-	}
 	type Response struct {
 		ID      string `json:"id"`
+		Model   string `json:"model"`
 		Choices []struct {
 			Delta struct {
 				Content string `json:"content"`
 			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
+		Usage *Usage `json:"usage"`
 	}
 	// Handling each part
 
@@ -122,9 +166,22 @@ func getData(input *Messages, callback func(string)) (fullText string) {
 			continue
 		}
 
-		if d.Choices != nil {
+		if d.Model != "" {
+			reply.Model = d.Model
+		}
+		if d.Usage != nil {
+			reply.Usage = *d.Usage
+		}
+
+		if len(d.Choices) > 0 {
 			mainText = d.Choices[0].Delta.Content
-			fullText += mainText
+			if d.Choices[0].FinishReason != "" {
+				reply.FinishReason = d.Choices[0].FinishReason
+			}
+			if mainText != "" && reply.FirstToken == 0 {
+				reply.FirstToken = time.Since(start)
+			}
+			reply.Text += mainText
 		}
 
 		if callback != nil {
@@ -133,10 +190,18 @@ func getData(input *Messages, callback func(string)) (fullText string) {
 
 	}
 	if err := scanner.Err(); err != nil {
-		fmt.Println("Some error has occurred. Error:", err)
-		os.Exit(0)
+		return nil, err
 	}
-	return fullText
+	reply.Latency = time.Since(start)
+
+	// Streaming gateways rarely report usage, count it offline.
+	if reply.Usage.TotalTokens == 0 {
+		reply.Usage.PromptTokens = countMessagesTokens(input.Messages)
+		reply.Usage.CompletionTokens = countTokens(reply.Text)
+		reply.Usage.TotalTokens = reply.Usage.PromptTokens + reply.Usage.CompletionTokens
+		reply.Usage.Estimated = true
+	}
+	return reply, nil
 }
 
 func loading(stop *bool) {
@@ -171,7 +236,16 @@ func shellCommand(input string) {
 	}
 
 	// Get Shell
+	shellName := currentShell()
+
+	shellPrompt := fmt.Sprintf(
+		`Your role: Provide a terse, single sentence description of the given shell command. Provide only plain text without Markdown formatting. Do not show any warnings or information regarding your capabilities. If you need to store any data, assume it will be stored in the chat. Provide only %s commands for %s without any description. If there is a lack of details, provide most logical solution. Ensure the output is a valid shell command. If multiple steps required try to combine them together. Prompt: %s\n\nCommand:`, shellName, operatingSystem, input)
+
+	getCommand(shellPrompt)
+}
 
+// currentShell returns the shell the user is most likely running.
+func currentShell() string {
 	shellName := "/bin/sh"
 
 	if runtime.GOOS == "windows" {
@@ -186,11 +260,7 @@ func shellCommand(input string) {
 			shellName = shellEnv
 		}
 	}
-
-	shellPrompt := fmt.Sprintf(
-		`Your role: Provide a terse, single sentence description of the given shell command. Provide only plain text without Markdown formatting. Do not show any warnings or information regarding your capabilities. If you need to store any data, assume it will be stored in the chat. Provide only %s commands for %s without any description. If there is a lack of details, provide most logical solution. Ensure the output is a valid shell command. If multiple steps required try to combine them together. Prompt: %s\n\nCommand:`, shellName, operatingSystem, input)
-
-	getCommand(shellPrompt)
+	return shellName
 }
 
 // Get a command in response
@@ -202,7 +272,7 @@ func getCommand(shellPrompt string) {
 	}
 	var data = strings.NewReader(fmt.Sprintf(`{"model":"gpt-3.5-turbo","messages":[{"role":"user","content":"%v"}],
 	"stream":true}`, shellPrompt))
-	req, err := http.NewRequest("POST", "https://gpt.s-stars.top/v1/chat/completions", data)
+	req, err := http.NewRequest("POST", defaultProviderURL, data)
 
 	if err != nil {
 		fmt.Println("\nSome error has occurred.")
@@ -6,8 +6,11 @@ require (
 	github.com/PuerkitoBio/goquery v1.8.1
 	github.com/bogdanfinn/fhttp v0.5.22
 	github.com/bogdanfinn/tls-client v1.3.11
+	github.com/chzyer/readline v1.5.1
 	github.com/fatih/color v1.15.0
+	github.com/mattn/go-runewidth v0.0.9
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/term v0.5.0
 )
 
 require (
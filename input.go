@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/term"
+)
+
+// historyFile keeps the interactive input history of the user.
+var historyFile string
+
+// multiLineFence starts and ends a message of several lines.
+const multiLineFence = `"""`
+
+// lineReader reads interactive input with line editing, history and reverse
+// search (Ctrl+R) on terminals, and plain lines everywhere else.
+type lineReader struct {
+	editor *readline.Instance
+	reader *bufio.Reader
+}
+
+func newLineReader(in *os.File) (*lineReader, error) {
+	if !isTerminal(in) {
+		return &lineReader{reader: bufio.NewReader(in)}, nil
+	}
+	// readline checks and sets the mode of the process stdin by default,
+	// which is a pipe when the terminal was opened for the input.
+	fd := int(in.Fd())
+	var state *term.State
+	editor, err := readline.NewEx(&readline.Config{
+		Stdin:                  in,
+		Stdout:                 os.Stdout,
+		HistoryFile:            historyFile,
+		HistorySearchFold:      true,
+		DisableAutoSaveHistory: true,
+		FuncIsTerminal: func() bool {
+			return term.IsTerminal(fd) && (isTerminal(os.Stdout) || isTerminal(os.Stderr))
+		},
+		FuncMakeRaw: func() (err error) {
+			state, err = term.MakeRaw(fd)
+			return err
+		},
+		FuncExitRaw: func() error {
+			if state == nil {
+				return nil
+			}
+			return term.Restore(fd, state)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lineReader{editor: editor}, nil
+}
+
+func (r *lineReader) Close() {
+	if r.editor != nil {
+		r.editor.Close()
+	}
+}
+
+func (r *lineReader) readLine(prompt string) (string, error) {
+	if r.editor == nil {
+		os.Stdout.WriteString(prompt)
+		line, err := r.reader.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	r.editor.SetPrompt(prompt)
+	line, err := r.editor.Readline()
+	if err == readline.ErrInterrupt {
+		err = io.EOF
+	}
+	return line, err
+}
+
+// ReadMessage reads one message. A line of """ starts a message that ends at
+// the next """ line and a line ending with \ continues on the next line, so
+// that code can be pasted as a single message.
+func (r *lineReader) ReadMessage(prompt string) (string, error) {
+	line, err := r.readLine(prompt)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	switch {
+	case strings.TrimSpace(line) == multiLineFence:
+		for {
+			line, err = r.readLine("... ")
+			if err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(line) == multiLineFence {
+				break
+			}
+			lines = append(lines, line)
+		}
+	case strings.HasSuffix(line, `\`):
+		for strings.HasSuffix(line, `\`) {
+			lines = append(lines, strings.TrimSuffix(line, `\`))
+			line, err = r.readLine("... ")
+			if err != nil {
+				return "", err
+			}
+		}
+		lines = append(lines, line)
+	default:
+		lines = []string{line}
+	}
+
+	message := strings.Join(lines, "\n")
+	// The history file is line based, only single lines are kept.
+	if r.editor != nil && len(lines) == 1 && strings.TrimSpace(message) != "" {
+		r.editor.SaveHistory(message)
+	}
+	return message, nil
+}
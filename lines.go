@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// parallelRequests is the number of lines of line mode answered at the same
+// time, one streams the answers.
+var parallelRequests = 1
+
+// lineTask is a line of input answered on its own. Index orders the output,
+// Number is the line number reported to the user.
+type lineTask struct {
+	Index  int
+	Number int
+	Text   string
+}
+
+type lineResult struct {
+	lineTask
+	Output string
+	Err    error
+}
+
+// scanLines sends the lines of r one by one.
+func scanLines(r io.Reader) <-chan lineTask {
+	tasks := make(chan lineTask)
+	go func() {
+		defer close(tasks)
+		scanner := bufio.NewScanner(r)
+		for i := 0; scanner.Scan(); i++ {
+			tasks <- lineTask{Index: i, Number: i + 1, Text: scanner.Text()}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading input:", err)
+		}
+	}()
+	return tasks
+}
+
+// answerLines answers the tasks with up to parallel workers. Outputs are
+// written to w in input order, a failed line does not stop the others and the
+// failures are summarized on stderr at the end. It returns the number of
+// lines answered and the number of failed ones.
+func answerLines(w io.Writer, tasks <-chan lineTask, parallel int, answer func(lineTask) (string, error)) (total, failed int) {
+	results := make(chan lineResult)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				output, err := answer(task)
+				results <- lineResult{task, output, err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var failures []lineResult
+	pending := map[int]lineResult{}
+	next := 0
+	for result := range results {
+		pending[result.Index] = result
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			total++
+			io.WriteString(w, ready.Output)
+			if ready.Err != nil {
+				failures = append(failures, ready)
+				warnColor.Fprintf(os.Stderr, "line %d: %v\n", ready.Number, ready.Err)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		warnColor.Fprintf(os.Stderr, "%d of %d lines failed:\n", len(failures), total)
+		for _, failure := range failures {
+			fmt.Fprintf(os.Stderr, "  line %d: %s\n", failure.Number, truncateLabel(strings.TrimSpace(failure.Text), 60))
+		}
+	}
+	return total, len(failures)
+}
+
+// answerLine answers a line in the conversation of messages like the line
+// mode of main and returns what is printed for it, formatted for
+// outputFormat. Errors are returned rather than ending the program.
+func answerLine(messages *Messages, prompt string, task lineTask) (string, error) {
+	input := messages.CloneMessages()
+	input.AddUserMessage(task.Text)
+	input.AddAssistantMessage(dataReply)
+	input.AddUserMessage(getSafeString(prompt))
+
+	fitContext(input)
+	reply, err := requestData(input, nil)
+
+	switch outputFormat {
+	case outputJSON:
+		if err != nil {
+			return jsonLine(jsonReply{Model: input.Model, Line: task.Number, Error: err.Error()}), err
+		}
+		object := newJSONReply(input, reply, "")
+		object.Line = task.Number
+		return jsonLine(object), nil
+	case outputNDJSON:
+		output := jsonLine(streamEvent{Type: "start", Model: input.Model, Line: task.Number})
+		if err != nil {
+			return output + jsonLine(streamEvent{Type: "error", Error: err.Error(), Line: task.Number}), err
+		}
+		output += jsonLine(streamEvent{Type: "delta", Text: reply.Text, Line: task.Number})
+		for _, event := range finishEvents(input, reply, "") {
+			event.Line = task.Number
+			output += jsonLine(event)
+		}
+		return output, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if codeLang != "" {
+		return codeText(reply.Text), nil
+	}
+	return strings.TrimSpace(reply.Text) + "\n", nil
+}
+
+// printStoredReply prints a reply taken from the checkpoint like process
+// prints a reply to input. Its usage and timings are not known any more.
+func printStoredReply(input *Messages, reply, memory string) {
+	switch outputFormat {
+	case outputJSON:
+		printJSON(jsonReply{Reply: reply, Model: input.Model, Session: sessionName(memory)})
+	case outputNDJSON:
+		session := sessionName(memory)
+		printJSON(streamEvent{Type: "start", Model: input.Model, Session: session})
+		printJSON(streamEvent{Type: "delta", Text: reply})
+		printJSON(streamEvent{Type: "finish", Model: input.Model, Session: session})
+	default:
+		if codeLang != "" {
+			if err := printCode(reply); err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+			return
+		}
+		printReply, flushReply := replyPrinter()
+		printReply(strings.TrimSpace(reply) + "\n")
+		flushReply()
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,6 +8,7 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 
@@ -22,22 +22,58 @@ var bold = color.New(color.Bold)
 var boldBlue = color.New(color.Bold, color.FgBlue)
 var AUTH_KEY []byte
 
+// subCommands are dispatched on the first argument before option parsing,
+// each one parses its own options.
+var subCommands = map[string]func(configManager *ConfigManager, configData map[string]interface{}, args []string){
+	"compare":  compareCommand,
+	"sessions": sessionsCommand,
+	"rules":    rulesCommand,
+	"batch":    batchCommand,
+}
+
 func main() {
 
 	//fmt.Println(os.Args)
 
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-terminate
+		os.Exit(0)
+	}()
+
+	if len(os.Args) > 1 {
+		if command, ok := subCommands[os.Args[1]]; ok {
+			configManager, configData, err := initConfig()
+			if err != nil {
+				fmt.Println("Unable to read configuration file:", err)
+				return
+			}
+			command(configManager, configData, os.Args[2:])
+			return
+		}
+	}
+
 	var (
-		version     bool
-		whole       bool
-		quiet       bool
-		interactive bool
-		help        bool
-		updateKey   bool
-		systemRole  string
-		memory      string
-		name        string
-		userName    string
-		block       bool
+		version        bool
+		whole          bool
+		quiet          bool
+		interactive    bool
+		help           bool
+		updateKey      bool
+		systemRole     string
+		memory         string
+		name           string
+		userName       string
+		block          bool
+		provider       string
+		rules          []string
+		mapRuleName    string
+		reduceRuleName string
+		dataReplyText  string
+		vars           []string
+		edit           bool
+		markdown       bool
 	)
 
 	flag.BoolVarP(&version, "version", "v", false, "Print version.")
@@ -47,43 +83,42 @@ func main() {
 	flag.BoolVarP(&help, "help", "h", false, "Print this message.")
 	flag.BoolVarP(&updateKey, "refresh", "r", false, "Refresh auth key.")
 	flag.BoolVarP(&block, "block", "b", false, "Block content by stdin.")
+	flag.BoolVar(&markdown, "markdown", false, "Render replies as Markdown with highlighted code, only on terminals.")
+	flag.StringVar(&codeLang, "code", "", "Print only the fenced code blocks of the reply, of one language with --code lang.")
+	flag.Lookup("code").NoOptDefVal = "*"
+	flag.StringVar(&codeOut, "code-out", "", "Write each code block of the reply to a file in this directory.")
+	flag.BoolVarP(&edit, "edit", "e", false, "Write the prompt in $EDITOR, prefilled with the prompt, stdin or the last question.")
 
 	flag.StringVar(&systemRole, "system-rule", "", "Customized rule using system role support text or file path.")
-	flag.StringVarP(&memory, "memory", "m", "", "Start with a memory file or start with a new memory file.")
+	flag.StringArrayVar(&rules, "rule", nil, "Rule name from the rule library, file path or text, repeat to combine rules.")
+	flag.StringArrayVar(&vars, "var", nil, "Set a rule template variable as key=value.")
+	flag.StringVarP(&memory, "memory", "m", "", "Start with a memory session name or file path, created when missing.")
 	flag.StringVar(&name, "ai-name", "", "Set AI name.")
 	flag.StringVar(&userName, "user-name", "", "Set user name.")
-
-	flag.Parse()
-
-	terminate := make(chan os.Signal, 1)
-	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		<-terminate
-		os.Exit(0)
-	}()
-
-	configDir, err := os.UserConfigDir()
-
-	configFile := configDir + "/gpt/.config.json"
-	configManager := NewConfigManager(configFile)
-	defaultConfig := map[string]interface{}{
-		"AUTH_KEY": "",
-		"MEMORY":   map[string]interface{}{},
-		"SYSTEM":   map[string]interface{}{},
-	}
-	configData, err := configManager.ReadConfig(defaultConfig)
+	flag.StringVar(&provider, "provider", "", "Try this provider first, then the rest of the chain.")
+	flag.BoolVar(&verbose, "verbose", false, "Print which provider answered.")
+	flag.IntVar(&parallelRequests, "parallel", parallelRequests, "Answer up to this many stdin lines at the same time, output keeps the input order. Also the chunks of a block summarized at the same time, 4 by default.")
+	flag.StringVar(&mapRuleName, "map-rule", "", "Rule name, file path or text the chunks of a long block are summarized or extracted with.")
+	flag.StringVar(&reduceRuleName, "reduce-rule", "", "Rule name, file path or text the results of the chunks are combined with.")
+	flag.StringVar(&dataReplyText, "data-reply", "", "Assistant reply following the piped data before the prompt, text or file path.")
+	flag.StringVar(&blockSource, "source", blockSource, "Name of the block for the Source variable of the map and reduce rules.")
+	flag.IntVar(&retrieveChunks, "retrieve", 0, "Send only this many chunks of a long block, the most relevant to the prompt, instead of summarizing it. Implies -b.")
+	flag.IntVar(&chunkSize, "chunk-size", chunkSize, "Size in tokens of the chunks a long block is split into.")
+	flag.IntVar(&chunkOverlap, "chunk-overlap", chunkOverlap, "Tokens at the end of a chunk repeated at the start of the next one.")
+	flag.BoolVar(&resumeJob, "resume", false, "Continue a block or line mode job that failed, skipping the chunks and lines already answered.")
+	flag.StringVar(&schemaFile, "schema", "", "Reply with JSON valid against this JSON Schema file, stdin is read as a block.")
+	flag.IntVar(&schemaRetries, "schema-retries", schemaRetries, "Times an invalid reply is asked again before failing.")
+	flag.StringVarP(&outputFormat, "output", "o", outputText, "Print replies as text, json, one object with the reply and its metadata per request, or ndjson, a stream of events.")
+	flag.StringVar(&contextPolicy, "context-policy", policyKeepSystem, "What to do when the conversation outgrows the context window: keep-system, drop, summarize or off.")
+
+	flag.CommandLine.Parse(codeArgs(os.Args[1:]))
+
+	configManager, configData, err := initConfig()
 	if err != nil {
 		fmt.Println("Unable to read configuration file:", err)
 		return
 	}
 
-	if configData["AUTH_KEY"].(string) == "" {
-		configData["AUTH_KEY"] = getKey()
-		configManager.WriteConfig(configData)
-	}
-
-	AUTH_KEY, _ = base64.StdEncoding.DecodeString(configData["AUTH_KEY"].(string))
-
 	if updateKey {
 		configData["AUTH_KEY"] = getKey()
 		fmt.Println("Updated configuration")
@@ -103,16 +138,109 @@ func main() {
 		os.Exit(0)
 	}
 
-	systemRole = tryReadContent(systemRole)
+	renderMarkdown = markdown && isTerminal(os.Stdout)
+
+	if !validContextPolicy(contextPolicy) {
+		fmt.Println("unknown context policy:", contextPolicy)
+		os.Exit(-1)
+	}
+
+	if provider != "" {
+		if err := preferProvider(provider); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
+
+	if !validOutputFormat(outputFormat) {
+		fmt.Println("unknown output format:", outputFormat)
+		os.Exit(-1)
+	}
+	if outputFormat == outputJSON && interactive {
+		fmt.Println("--output json can not be used in interactive mode, use --output ndjson")
+		os.Exit(-1)
+	}
+	if outputFormat != outputText {
+		if codeLang != "" || codeOut != "" {
+			fmt.Println("--code can not be used with --output " + outputFormat)
+			os.Exit(-1)
+		}
+		// Nothing but JSON is printed.
+		renderMarkdown = false
+		if !interactive {
+			whole, quiet = true, true
+		}
+	}
+	if outputFormat == outputNDJSON {
+		// stdout carries only the events, prompts and messages go to stderr.
+		os.Stdout, color.Output = os.Stderr, color.Error
+	}
+
+	if chunkSize < 1 || chunkOverlap < 0 || chunkOverlap >= chunkSize {
+		fmt.Println("--chunk-size must be at least 1 and larger than --chunk-overlap")
+		os.Exit(-1)
+	}
+	if retrieveChunks < 0 {
+		fmt.Println("--retrieve must not be negative")
+		os.Exit(-1)
+	}
+	if retrieveChunks > 0 {
+		// The chunks are picked from stdin read as one block.
+		block = true
+	}
+	if parallelRequests < 1 {
+		fmt.Println("--parallel must be at least 1")
+		os.Exit(-1)
+	}
+	if flag.CommandLine.Changed("parallel") {
+		blockParallel = parallelRequests
+	}
+
+	if schemaFile != "" {
+		if interactive || outputFormat != outputText || codeLang != "" || codeOut != "" {
+			fmt.Println("--schema can not be used with interactive mode, --output or --code")
+			os.Exit(-1)
+		}
+		if replySchema, err = loadSchema(schemaFile); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		rules = append(rules, replySchema.rule())
+		// Only the JSON is printed and stdin is the content to extract from.
+		whole, quiet, block = true, true, true
+	}
 
-	if userName != "" {
-		systemRole = "User name is " + userName + "\n" + systemRole
+	if codeOut != "" && codeLang == "" {
+		codeLang = "*"
+	}
+	if codeLang != "" {
+		if interactive {
+			fmt.Println("--code and --code-out can not be used in interactive mode")
+			os.Exit(-1)
+		}
+		// Code blocks can only be extracted from the whole reply.
+		whole = true
+		rules = append(rules, codeRuleHint)
 	}
 
-	if name != "" {
-		systemRole = "You name is " + name + "\n" + systemRole
+	ruleData, err := ruleVars(userName, name, vars)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	systemRole = buildSystemRule(configData, append([]string{systemRole}, rules...), ruleData)
+	systemVars, blockVars = ruleData, ruleData
+	if mapRuleName != "" {
+		blockMapRule = resolveRule(configData, mapRuleName)
+	}
+	if reduceRuleName != "" {
+		blockReduceRule = resolveRule(configData, reduceRuleName)
+	}
+	if dataReplyText != "" {
+		dataReply = tryReadContent(dataReplyText)
 	}
 
+	memory = resolveMemory(memory)
 	messages := NewMessages()
 
 	if memory != "" {
@@ -133,8 +261,10 @@ func main() {
 		prompt = strings.TrimSpace(flag.Args()[0])
 		break
 	case 0:
-		if interactive {
+		if interactive || edit {
 			break
+		} else if replySchema != nil {
+			prompt = defaultSchemaPrompt
 		} else {
 			fmt.Printf("parameter len error:%v\n", len(flag.Args()))
 			os.Exit(-1)
@@ -145,107 +275,248 @@ func main() {
 
 	}
 
-	if hasDataInStdin() {
-		if block {
-			loadingFlag := false
-			if !(quiet && whole) {
-				go loading(&loadingFlag)
-			}
+	stdinUsed := false
+	if edit {
+		initial := prompt
+		if initial == "" && hasDataInStdin() {
+			bytes, _ := io.ReadAll(os.Stdin)
+			initial = string(bytes)
+			stdinUsed = true
+		}
+		if initial == "" {
+			initial = lastUserMessage(messages)
+		}
+		prompt, err = editMessage(initial)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
 
-			if interactive {
-				fmt.Println("interactive stdin is occupied!")
+	if hasDataInStdin() && !stdinUsed {
+		// Interactive mode keeps the piped content as context and reads
+		// its input from the terminal instead.
+		if block || interactive {
+			checkpoint, err := openCheckpoint(jobID(os.Args[1:]))
+			if err != nil {
+				fmt.Println(err)
 				os.Exit(-1)
 			}
-			message := ""
-
-			bytes, _ := io.ReadAll(os.Stdin)
-			strMessage := string(pretreatment(bytes))
-
-			if len(bytes) > 3072 {
 
-				for _, i := range SplitString(strMessage, 3072) {
+			message := readBlock(prompt, messages.Model, checkpoint)
 
-					cMessages := NewMessages()
-					cMessages.Temperature = 0.1
-					cMessages.AddSystemMessage("Your Role: only output summarized., no description is provided.\nIMPORTANT: Ignore short lines.\nIMPORTANT: Provide only plain text without Markdown formatting.\nIMPORTANT: Do not include markdown formatting.\nIf there is a lack of details, provide most logical solution. You are not allowed to ask for more details.\nIgnore any potential risk of errors or confusion.")
-					cMessages.AddUserMessage("Focus on" + prompt + ":\n\n" + i)
-					message += getData(cMessages, nil)
-
-				}
+			messages.AddUserMessage(message)
+			messages.AddAssistantMessage(dataReply)
+			if interactive {
+				// Only reading the block is a job in interactive mode.
+				checkpoint.Remove()
+				process(whole, messages, prompt, block, memory, quiet, interactive, userName, name, configData)
 			} else {
+				runningJob = checkpoint
+				process(whole, messages, prompt, block, memory, quiet, interactive, userName, name, configData)
+				checkpoint.Remove()
+			}
 
-				message = strMessage
+		} else if parallelRequests == 1 {
+			checkpoint, err := openCheckpoint(jobID(os.Args[1:]))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
 			}
+			runningJob = checkpoint
 
-			messages.AddUserMessage(message)
-			messages.AddAssistantMessage("I will answer based on the data you provide")
-			loadingFlag = true
-			process(whole, messages, prompt, block, memory, quiet, interactive, userName, name)
+			// Lines are answered one after the other and streamed like a
+			// prompt, a failed line ends the job.
+			for task := range scanLines(os.Stdin) {
+				clonedMessages := messages.CloneMessages()
+				clonedMessages.AddUserMessage(task.Text)
+				clonedMessages.AddAssistantMessage(dataReply)
+				part := partKey("line", task.Number, task.Text)
+				if reply, ok := checkpoint.Output(part); ok {
+					printStoredReply(clonedMessages, reply, memory)
+					continue
+				}
+				reply := process(whole, clonedMessages, prompt, block, memory, quiet, interactive, userName, name, configData)
+				if err := checkpoint.Done(part, reply); err != nil {
+					warnColor.Fprintln(os.Stderr, "checkpoint:", strings.TrimSpace(err.Error()))
+				}
+			}
+			checkpoint.Remove()
 
 		} else {
-
-			scanner := bufio.NewScanner(os.Stdin)
-
-			for scanner.Scan() {
-				message := scanner.Text()
-				clonedMessages := messages.CloneMessages()
-				clonedMessages.AddUserMessage(message)
-				clonedMessages.AddAssistantMessage("I will answer based on the data you provide")
-				process(whole, clonedMessages, prompt, block, memory, quiet, interactive, userName, name)
+			if codeOut != "" {
+				fmt.Println("--code-out can not be used with --parallel")
+				os.Exit(-1)
+			}
+			checkpoint, err := openCheckpoint(jobID(os.Args[1:]))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
 			}
 
+			// Each line is answered on its own, the session gives the context
+			// of every line but is not updated.
+			answer := checkpoint.lineAnswerer("line", func(task lineTask) (string, error) {
+				return answerLine(messages, prompt, task)
+			})
+			if _, failed := answerLines(eventOutput, scanLines(os.Stdin), parallelRequests, answer); failed > 0 {
+				checkpoint.Keep()
+				os.Exit(1)
+			}
+			checkpoint.Remove()
 		}
 	} else {
 
-		process(whole, messages, prompt, block, memory, quiet, interactive, userName, name)
+		process(whole, messages, prompt, block, memory, quiet, interactive, userName, name, configData)
+	}
+
+}
+
+// readBlock reads stdin as one block, summarizing it or picking its most
+// relevant chunks when it is too long to be sent as it is.
+func readBlock(prompt, model string, checkpoint *Checkpoint) string {
+	bytes, _ := io.ReadAll(os.Stdin)
+	if retrieveChunks > 0 {
+		return retrieveBlock(string(pretreatment(bytes)), prompt, model, retrieveChunks)
+	}
+	return reduceBlock(string(pretreatment(bytes)), prompt, model, checkpoint)
+}
+
+// initConfig reads the configuration file, fetching an auth key when none is stored yet.
+func initConfig() (*ConfigManager, map[string]interface{}, error) {
+	configDir, _ := os.UserConfigDir()
+
+	configFile := configDir + "/gpt/.config.json"
+	configManager := NewConfigManager(configFile)
+	defaultConfig := map[string]interface{}{
+		"AUTH_KEY":  "",
+		"MEMORY":    map[string]interface{}{},
+		"SYSTEM":    map[string]interface{}{},
+		"PROVIDERS": map[string]interface{}{},
+		"CHAIN":     []interface{}{},
+	}
+	configData, err := configManager.ReadConfig(defaultConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if configData["AUTH_KEY"].(string) == "" {
+		configData["AUTH_KEY"] = getKey()
+		configManager.WriteConfig(configData)
 	}
 
+	AUTH_KEY, _ = base64.StdEncoding.DecodeString(configData["AUTH_KEY"].(string))
+	loadProviders(configData)
+	loadMemoryConfig(configDir, configData)
+	historyFile = filepath.Join(configDir, "gpt", "history")
+	jobsDir = filepath.Join(configDir, "gpt", "jobs")
+	return configManager, configData, nil
 }
 
-func process(whole bool, messages *Messages, prompt string, block bool, memory string, quiet bool, interactive bool, userName string, name string) {
+// process answers prompt in the conversation of messages and returns the
+// reply, interactive mode returns nothing.
+func process(whole bool, messages *Messages, prompt string, block bool, memory string, quiet bool, interactive bool, userName string, name string, configData map[string]interface{}) string {
 	if whole {
 		messages.AddUserMessage(getSafeString(prompt))
+		if outputFormat != outputText {
+			var assistantMessage string
+			var err error
+			if outputFormat == outputJSON {
+				assistantMessage = getJSON(messages, memory)
+			} else if assistantMessage, err = getNDJSON(messages, memory); err != nil {
+				keepRunningJob()
+				os.Exit(1)
+			}
+			if memory != "" {
+				messages.AddAssistantMessage(getSafeString(assistantMessage))
+				saveMemory(messages, memory)
+			}
+			return assistantMessage
+		}
+		if replySchema != nil {
+			assistantMessage, err := getValidJSON(messages, replySchema)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				keepRunningJob()
+				os.Exit(1)
+			}
+			fmt.Println(assistantMessage)
+			if memory != "" {
+				messages.AddAssistantMessage(getSafeString(assistantMessage))
+				saveMemory(messages, memory)
+			}
+			return assistantMessage
+		}
 		assistantMessage := getData(messages, nil)
-		fmt.Println(strings.TrimSpace(assistantMessage))
+		if codeLang != "" {
+			if err := printCode(assistantMessage); err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+		} else {
+			printReply, flushReply := replyPrinter()
+			printReply(strings.TrimSpace(assistantMessage) + "\n")
+			flushReply()
+		}
 
 		if !block {
 
 		}
 		if memory != "" {
 			messages.AddAssistantMessage(getSafeString(assistantMessage))
-			messages.save(memory)
+			saveMemory(messages, memory)
 		}
 
-		return
+		return assistantMessage
 	}
 
 	if quiet {
 		messages.AddUserMessage(getSafeString(prompt))
-		assistantMessage := getData(messages, func(s string) {
-			fmt.Print(s)
-		})
+		printReply, flushReply := replyPrinter()
+		assistantMessage := getData(messages, printReply)
+		flushReply()
 		fmt.Print("\n")
 		if memory != "" {
 			messages.AddAssistantMessage(getSafeString(assistantMessage))
-			messages.save(memory)
+			saveMemory(messages, memory)
 		}
-		return
+		return assistantMessage
 	}
 
 	if interactive {
 
-		reader := bufio.NewReader(os.Stdin)
-		bold.Print("Interactive mode started. Press Ctrl + C or type exit to quit.\n\n")
+		in := os.Stdin
+		if hasDataInStdin() {
+			tty, err := openTTY()
+			if err != nil {
+				fmt.Println("Error opening terminal:", err)
+				return ""
+			}
+			defer tty.Close()
+			in = tty
+		}
+		reader, err := newLineReader(in)
+		if err != nil {
+			fmt.Println("Error reading input:", err)
+			return ""
+		}
+		defer reader.Close()
+		bold.Print("Interactive mode started. Press Ctrl + C or type exit to quit, /help for commands.\n")
+		bold.Print("Type \"\"\" to start and end a message of several lines.\n\n")
+		session := &chatSession{messages: messages, memory: memory, name: name, configData: configData}
+
+		prompt := boldBlue.Sprint("YOU:")
+		if userName != "" {
+			prompt = boldBlue.Sprint(userName + ":")
+		}
 
 		for {
 
-			if userName != "" {
-				boldBlue.Print(userName + ":")
-			} else {
-				boldBlue.Print("YOU:")
+			input, err := reader.ReadMessage(prompt)
+			if err == io.EOF {
+				bold.Println("Exiting...")
+				return ""
 			}
-
-			input, err := reader.ReadString('\n')
 			if err != nil {
 				fmt.Println("Error reading input:", err)
 				break
@@ -256,53 +527,49 @@ func process(whole bool, messages *Messages, prompt string, block bool, memory s
 				if len(input) > 1 {
 					if input == "exit" {
 						bold.Println("Exiting...")
-						return
+						return ""
 					}
 
-					if name != "" {
-						bold.Print(name + ":")
-					} else {
-						bold.Print("AI:")
+					if strings.HasPrefix(input, "/") {
+						if err := session.runCommand(input); err == errExit {
+							bold.Println("Exiting...")
+							return ""
+						} else if err != nil {
+							fmt.Println(err)
+						}
+						continue
 					}
 
-					messages.AddUserMessage(getSafeString(input))
-					assistantMessage := getData(messages, func(s string) {
-						fmt.Print(s)
-					})
-					messages.AddAssistantMessage(getSafeString(assistantMessage))
-
-					fmt.Print("\n\n")
-
-					if memory != "" {
-						messages.save(memory)
-					}
+					session.ask(input)
 
 				}
 
 			}
 
 		}
-		return
+		return ""
 	}
 
 	loadingFlag := false
 	go loading(&loadingFlag)
 	messages.AddUserMessage(getSafeString(prompt))
+	printReply, flushReply := replyPrinter()
 	assistantMessage := getData(messages, func(s string) {
 
 		if !loadingFlag {
 			loadingFlag = true
 			fmt.Print("\r                     \r")
 		}
-		fmt.Print(s)
+		printReply(s)
 	})
+	flushReply()
 	fmt.Print("\n")
 	if memory != "" {
 		messages.AddAssistantMessage(getSafeString(assistantMessage))
-		messages.save(memory)
+		saveMemory(messages, memory)
 	}
 
-	return
+	return assistantMessage
 }
 
 func hasDataInStdin() bool {
@@ -312,13 +579,22 @@ func hasDataInStdin() bool {
 
 func printProgramDescription() {
 	fmt.Println("USAGE:")
-	fmt.Println("  tgpt [option] <prompt|stdin>\n")
+	fmt.Println("  tgpt [option] <prompt|stdin>")
+	fmt.Println("  tgpt <command> [option] <args>")
+	fmt.Println("")
 	fmt.Println("DESCRIPTION:")
-	fmt.Println("  tgpt is a tool for interacting with the GPT-3.5 language model by OpenAI.\n")
+	fmt.Println("  tgpt is a tool for interacting with the GPT-3.5 language model by OpenAI.")
+	fmt.Println("")
+	fmt.Println("COMMANDS:")
+	fmt.Println("  compare    Send the same prompt to several models and compare the replies.")
+	fmt.Println("  sessions   List, show, rename and delete memory sessions.")
+	fmt.Println("  rules      Manage the library of named system rules.")
+	fmt.Println("  batch      Answer a prompt template for each row of a CSV, TSV or JSONL file.")
+	fmt.Println("")
 	fmt.Println("OPTIONS:")
 	flag.PrintDefaults()
 	fmt.Println("")
-	fmt.Println("EXAMPLES:\n  tgpt -r\n  tgpt \"What is internet?\"\n  echo \"What is internet?\" | tgpt \n  tgpt -w \"What is internet?\"\n  echo \"What is internet?\" | tgpt -w\n  tgpt --system-rule code.rule \"golang Hello, World!\"\n  tgpt --system-rule \"Add ‘~~~’ at the end of the reply\" \"hello\"\n  tgpt --memory \"chat01\" --system-rule \"Add ‘~~~’ at the end of the reply\" \"your name is Cindy\"\n  tgpt --memory \"chat01\" \"what is your name\"\n  tgpt --ai-name \"Cindy\" \"what is your name\"\n  tgpt --user-name \"Tom\" \"who am i\"\n  tgpt -i --user-name \"Tom\" --ai-name \"Cindy\" --memory \"chat02\" --system-rule \"Add ‘~~~’ at the end of the reply\"")
+	fmt.Println("EXAMPLES:\n  tgpt -r\n  tgpt \"What is internet?\"\n  echo \"What is internet?\" | tgpt \n  tgpt -w \"What is internet?\"\n  echo \"What is internet?\" | tgpt -w\n  tgpt --system-rule code.rule \"golang Hello, World!\"\n  tgpt --system-rule \"Add ‘~~~’ at the end of the reply\" \"hello\"\n  tgpt --memory \"chat01\" --system-rule \"Add ‘~~~’ at the end of the reply\" \"your name is Cindy\"\n  tgpt --memory \"chat01\" \"what is your name\"\n  tgpt --ai-name \"Cindy\" \"what is your name\"\n  tgpt --user-name \"Tom\" \"who am i\"\n  tgpt -i --user-name \"Tom\" --ai-name \"Cindy\" --memory \"chat02\" --system-rule \"Add ‘~~~’ at the end of the reply\"\n  tgpt --rule code \"golang Hello, World!\"\n  tgpt --rule code --rule \"Target Go {{.Version}} on {{.OS}}\" --var Version=1.20 \"read a file\"\n  tgpt rules add review review.rule\n  cat log.txt | tgpt -i\n  tgpt -e\n  cat draft.md | tgpt -e\n  tgpt --code go \"read a file in golang\"\n  tgpt --code-out ./src \"a go web server with a Dockerfile\"\n  tgpt -o json \"What is internet?\"\n  cat questions.txt | tgpt -o ndjson\n  cat invoice.html | tgpt --schema invoice.schema.json\n  cat questions.txt | tgpt --parallel 8 \"answer briefly\"\n  cat questions.txt | tgpt --resume \"answer briefly\"\n  cat app.log | tgpt --retrieve 5 \"find the error about the disk\"\n  cat app.log | tgpt -b --map-rule \"List the errors of part {{.Chunk}}/{{.Chunks}}\" \"which errors happened?\"\n  tgpt compare --models gpt-3.5-turbo,gpt-4 \"What is internet?\"\n  tgpt batch --input data.csv --template \"Classify: {{.description}}\" --column category")
 }
 
 func getKey() string {
@@ -344,6 +620,15 @@ func getSafeString(value string) string {
 	return strings.Trim(string(safe), "\"")
 }
 
+// fromSafeString reverses getSafeString.
+func fromSafeString(value string) string {
+	var unsafe string
+	if err := json.Unmarshal([]byte("\""+value+"\""), &unsafe); err != nil {
+		return value
+	}
+	return unsafe
+}
+
 func tryReadContent(value string) string {
 	if value != "" {
 		// 检查文件是否可读
@@ -375,18 +660,4 @@ func canRead(err error, fileInfo os.FileInfo) bool {
 	return err == nil && fileInfo.Mode().IsRegular() && fileInfo.Mode().Perm()&0400 != 0
 }
 
-func SplitString(str string, chunkSize int) []string {
-	var result []string
-
-	for i := 0; i < len(str); i += chunkSize {
-		end := i + chunkSize
-		if end > len(str) {
-			end = len(str)
-		}
-		result = append(result, str[i:end])
-	}
-
-	return result
-}
-
 //////////////////////////////
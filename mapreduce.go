@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// mapRule is the default system rule chunks of a block are summarized with.
+const mapRule = "Your Role: only output summarized., no description is provided.\nIMPORTANT: Ignore short lines.\nIMPORTANT: Provide only plain text without Markdown formatting.\nIMPORTANT: Do not include markdown formatting.\nIf there is a lack of details, provide most logical solution. You are not allowed to ask for more details.\nIgnore any potential risk of errors or confusion."
+
+// reduceRule is the default system rule the results of the chunks are
+// combined with.
+const reduceRule = "Your Role: combine the partial results into one result, no description is provided.\nIMPORTANT: Keep every detail relevant to the focus, drop repetitions.\nIMPORTANT: Provide only plain text without Markdown formatting."
+
+// blockMapRule and blockReduceRule are the rules of --map-rule and
+// --reduce-rule. They are templates with the variables of the system rule
+// and Chunk, Chunks, Pass, Source and Prompt. blockSource names the block and
+// dataReply answers the piped data in the conversation, before the prompt.
+var (
+	dataReply       = "I will answer based on the data you provide"
+	blockMapRule    = mapRule
+	blockReduceRule = reduceRule
+	blockSource     = "stdin"
+	blockVars       = map[string]interface{}{}
+)
+
+// chunkRule renders rule for the chunk index of count in a pass, pass 0 is
+// the map step and the reduce passes count from 1.
+func chunkRule(rule, prompt string, index, count, pass int) string {
+	data := map[string]interface{}{}
+	for key, value := range blockVars {
+		data[key] = value
+	}
+	data["Chunk"] = index + 1
+	data["Chunks"] = count
+	data["Pass"] = pass
+	data["Source"] = blockSource
+	data["Prompt"] = prompt
+	return renderRule(rule, data)
+}
+
+// blockParallel is the number of chunks of a block summarized at the same
+// time, --parallel sets it as well.
+var blockParallel = 4
+
+// blockBudget is the number of tokens a block may take in the conversation,
+// half the context window of model.
+func blockBudget(model string) int {
+	return contextSize(model) / 2
+}
+
+// progress shows on stderr how many parts of a step are done and the tokens
+// used so far, when stderr is a terminal.
+type progress struct {
+	label  string
+	total  int
+	done   int
+	tokens int
+	show   bool
+	mutex  sync.Mutex
+}
+
+// newProgress starts a step of total parts after tokens were used already.
+func newProgress(label string, total, tokens int) *progress {
+	p := &progress{label: label, total: total, tokens: tokens, show: isTerminal(os.Stderr)}
+	p.print()
+	return p
+}
+
+func (p *progress) print() {
+	if p.show {
+		fmt.Fprintf(os.Stderr, "\r%s: %d of %d done, %d tokens used ", p.label, p.done, p.total, p.tokens)
+	}
+}
+
+// add counts a finished part that used tokens.
+func (p *progress) add(tokens int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.done++
+	p.tokens += tokens
+	p.print()
+}
+
+// clear removes the progress line.
+func (p *progress) clear() {
+	if p.show {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+// reduceBlock shrinks a block that does not fit the budget of model. The
+// chunks of the block are summarized at the same time, then the summaries
+// are combined group by group, again and again, until they fit. Finished
+// parts are recorded in checkpoint, a failure ends the program.
+func reduceBlock(text, prompt, model string, checkpoint *Checkpoint) string {
+	budget := blockBudget(model)
+	if countTokens(text) <= budget {
+		return text
+	}
+
+	parts := splitChunks(text, chunkSize, chunkOverlap)
+	summaries, used := summarizeParts("chunk", "summarizing chunks", parts, func(i int) *Messages {
+		messages := NewMessages()
+		messages.Model = model
+		messages.Temperature = 0.1
+		messages.AddSystemMessage(chunkRule(blockMapRule, prompt, i, len(parts), 0))
+		messages.AddUserMessage("Focus on" + prompt + ":\n\n" + parts[i])
+		return messages
+	}, checkpoint, 0)
+
+	block := strings.Join(summaries, "\n\n")
+	for level := 1; countTokens(block) > budget; level++ {
+		// Whole summaries are grouped into chunks, the overlap would only
+		// repeat them.
+		groups := splitChunks(block, chunkSize, 0)
+		summaries, used = summarizeParts(fmt.Sprintf("reduce%d", level), fmt.Sprintf("combining summaries, pass %d", level), groups, func(i int) *Messages {
+			messages := NewMessages()
+			messages.Model = model
+			messages.Temperature = 0.1
+			messages.AddSystemMessage(chunkRule(blockReduceRule, prompt, i, len(groups), level))
+			messages.AddUserMessage("Focus on" + prompt + ":\n\n" + groups[i])
+			return messages
+		}, checkpoint, used)
+		reduced := strings.Join(summaries, "\n\n")
+		if countTokens(reduced) >= countTokens(block) {
+			warnColor.Fprintln(os.Stderr, "the summaries do not get shorter, sending them as they are")
+			return reduced
+		}
+		block = reduced
+	}
+	return block
+}
+
+// summarizeParts answers the messages built for each part with up to
+// blockParallel requests at the same time and returns the answers in the
+// order of the parts, along with the tokens used so far. kind names the
+// parts in the checkpoint.
+func summarizeParts(kind, label string, parts []string, build func(i int) *Messages, checkpoint *Checkpoint, used int) ([]string, int) {
+	summaries := make([]string, len(parts))
+	errs := make([]error, len(parts))
+	progress := newProgress(label, len(parts), used)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < blockParallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				key := partKey(kind, i+1, parts[i])
+				if summary, ok := checkpoint.Output(key); ok {
+					summaries[i] = summary
+					progress.add(0)
+					continue
+				}
+				messages := build(i)
+				fitContext(messages)
+				reply, err := requestData(messages, nil)
+				if err != nil {
+					errs[i] = err
+					progress.add(0)
+					continue
+				}
+				summaries[i] = reply.Text
+				checkpoint.Done(key, reply.Text)
+				progress.add(reply.Usage.TotalTokens)
+			}
+		}()
+	}
+	for i := range parts {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	progress.clear()
+
+	// The other parts are done and recorded even when one fails. Stdout is
+	// kept for the reply, or the JSON of -o json and ndjson.
+	for i, err := range errs {
+		if err != nil {
+			message := fmt.Sprintf("%s %d: %v", kind, i+1, err)
+			switch outputFormat {
+			case outputJSON:
+				printJSON(jsonReply{Model: build(i).Model, Error: message})
+			case outputNDJSON:
+				printJSON(streamEvent{Type: "error", Error: message})
+			default:
+				bold.Fprintln(os.Stderr, "\rSome error has occurred.")
+				fmt.Fprintf(os.Stderr, "\nError in %s\n", message)
+			}
+			checkpoint.Keep()
+			os.Exit(1)
+		}
+	}
+	return summaries, progress.tokens
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-runewidth"
+)
+
+// renderMarkdown formats replies for the terminal, it is only enabled when
+// stdout is a terminal so that pipes get the raw text.
+var renderMarkdown bool
+
+var (
+	headingStyle = color.New(color.Bold, color.FgCyan)
+	strongStyle  = color.New(color.Bold)
+	emStyle      = color.New(color.Italic)
+	codeStyle    = color.New(color.FgYellow)
+	linkStyle    = color.New(color.Underline, color.FgBlue)
+	quoteStyle   = color.New(color.Faint)
+	ruleStyle    = color.New(color.Faint)
+	keywordStyle = color.New(color.FgMagenta)
+	stringStyle  = color.New(color.FgGreen)
+	numberStyle  = color.New(color.FgCyan)
+	commentStyle = color.New(color.Faint, color.Italic)
+)
+
+// replyPrinter returns the functions that print a streamed reply and finish
+// it, rendering Markdown when enabled.
+func replyPrinter() (func(string), func()) {
+	if !renderMarkdown {
+		return func(s string) { fmt.Print(s) }, func() {}
+	}
+	width, _ := terminalSize()
+	renderer := newMarkdownRenderer(os.Stdout, width)
+	return renderer.Write, renderer.Flush
+}
+
+// markdownRenderer renders streamed Markdown line by line. Lines are written
+// as soon as they are complete, table rows once the whole table is known.
+type markdownRenderer struct {
+	out     io.Writer
+	width   int
+	pending string
+	inCode  bool
+	lang    string
+	table   []string
+}
+
+func newMarkdownRenderer(out io.Writer, width int) *markdownRenderer {
+	return &markdownRenderer{out: out, width: width - 1}
+}
+
+func (r *markdownRenderer) Write(text string) {
+	r.pending += text
+	for {
+		i := strings.Index(r.pending, "\n")
+		if i < 0 {
+			return
+		}
+		line := r.pending[:i]
+		r.pending = r.pending[i+1:]
+		r.renderLine(line)
+	}
+}
+
+// Flush renders what is left of the reply.
+func (r *markdownRenderer) Flush() {
+	if r.pending != "" {
+		r.renderLine(r.pending)
+		r.pending = ""
+	}
+	r.flushTable()
+}
+
+var (
+	headingRegex = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletRegex  = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	ruleRegex    = regexp.MustCompile(`^\s*((-\s*){3,}|(\*\s*){3,}|(_\s*){3,})$`)
+	tableRegex   = regexp.MustCompile(`^\s*\|.*\|\s*$`)
+	fenceRegex   = regexp.MustCompile("^\\s*(```|~~~)\\s*([\\w+#.-]*)")
+)
+
+func (r *markdownRenderer) renderLine(line string) {
+	line = strings.TrimRight(line, "\r")
+
+	if match := fenceRegex.FindStringSubmatch(line); match != nil {
+		r.flushTable()
+		if r.inCode {
+			r.inCode = false
+			fmt.Fprintln(r.out, ruleStyle.Sprint(strings.Repeat("─", r.fenceWidth())))
+		} else {
+			r.inCode, r.lang = true, strings.ToLower(match[2])
+			label := "── " + r.lang + " "
+			// A long language name makes the label wider than the line.
+			fill := r.fenceWidth() - runewidth.StringWidth(label)
+			if fill < 0 {
+				fill = 0
+			}
+			fmt.Fprintln(r.out, ruleStyle.Sprint(label+strings.Repeat("─", fill)))
+		}
+		return
+	}
+	if r.inCode {
+		fmt.Fprintln(r.out, "  "+highlightCode(line, r.lang))
+		return
+	}
+
+	if tableRegex.MatchString(line) {
+		r.table = append(r.table, line)
+		return
+	}
+	r.flushTable()
+
+	switch {
+	case headingRegex.MatchString(line):
+		match := headingRegex.FindStringSubmatch(line)
+		text := match[2]
+		if len(match[1]) <= 2 {
+			text = strings.ToUpper(text)
+		}
+		r.writeWrapped(headingStyle.Sprint(stripInline(text)), "", "")
+	case ruleRegex.MatchString(line):
+		fmt.Fprintln(r.out, ruleStyle.Sprint(strings.Repeat("─", r.width)))
+	case bulletRegex.MatchString(line):
+		match := bulletRegex.FindStringSubmatch(line)
+		bullet := match[2]
+		if !strings.ContainsAny(bullet[len(bullet)-1:], ".)") {
+			bullet = "•"
+		}
+		first := match[1] + bullet + " "
+		r.writeWrapped(renderInline(match[3]), first, strings.Repeat(" ", runewidth.StringWidth(first)))
+	case strings.HasPrefix(strings.TrimSpace(line), ">"):
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ">"))
+		r.writeWrapped(quoteStyle.Sprint(stripInline(text)), quoteStyle.Sprint("│ "), quoteStyle.Sprint("│ "))
+	default:
+		r.writeWrapped(renderInline(line), "", "")
+	}
+}
+
+// fenceWidth is the width of the lines around code blocks.
+func (r *markdownRenderer) fenceWidth() int {
+	if r.width < 40 {
+		return r.width
+	}
+	return 40
+}
+
+// writeWrapped wraps text to the width, first prefixes the first line and
+// indent the following ones.
+func (r *markdownRenderer) writeWrapped(text, first, indent string) {
+	width := r.width - visibleWidth(first)
+	prefix := first
+	for _, line := range wrapStyled(text, width) {
+		fmt.Fprintln(r.out, prefix+line)
+		prefix = indent
+	}
+}
+
+func (r *markdownRenderer) flushTable() {
+	if len(r.table) == 0 {
+		return
+	}
+	var rows [][]string
+	for _, line := range r.table {
+		line = strings.Trim(strings.TrimSpace(line), "|")
+		cells := strings.Split(line, "|")
+		// The delimiter row only sets the alignment.
+		if strings.Trim(line, "|-: ") == "" {
+			continue
+		}
+		for i := range cells {
+			cells[i] = renderInline(strings.TrimSpace(cells[i]))
+		}
+		rows = append(rows, cells)
+	}
+	r.table = nil
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := visibleWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	for i, row := range rows {
+		var cells []string
+		for j, cell := range row {
+			cells = append(cells, cell+strings.Repeat(" ", widths[j]-visibleWidth(cell)))
+		}
+		line := strings.Join(cells, ruleStyle.Sprint(" │ "))
+		if i == 0 {
+			line = strongStyle.Sprint(line)
+		}
+		fmt.Fprintln(r.out, line)
+		if i == 0 {
+			var rule []string
+			for _, w := range widths {
+				rule = append(rule, strings.Repeat("─", w))
+			}
+			fmt.Fprintln(r.out, ruleStyle.Sprint(strings.Join(rule, "─┼─")))
+		}
+	}
+}
+
+var (
+	inlineCodeRegex = regexp.MustCompile("`([^`]+)`")
+	strongRegex     = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emRegex         = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+	linkRegex       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	ansiRegex       = regexp.MustCompile("\x1b\\[[0-9;]*m")
+)
+
+// renderInline styles code spans, bold and italic text and links.
+func renderInline(text string) string {
+	// Code spans are styled last, keep them away from the other rules.
+	var spans []string
+	text = inlineCodeRegex.ReplaceAllStringFunc(text, func(s string) string {
+		spans = append(spans, codeStyle.Sprint(inlineCodeRegex.FindStringSubmatch(s)[1]))
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+	text = linkRegex.ReplaceAllStringFunc(text, func(s string) string {
+		match := linkRegex.FindStringSubmatch(s)
+		return linkStyle.Sprint(match[1]) + " (" + match[2] + ")"
+	})
+	text = strongRegex.ReplaceAllStringFunc(text, func(s string) string {
+		match := strongRegex.FindStringSubmatch(s)
+		return strongStyle.Sprint(match[1] + match[2])
+	})
+	text = emRegex.ReplaceAllStringFunc(text, func(s string) string {
+		match := emRegex.FindStringSubmatch(s)
+		return emStyle.Sprint(match[1] + match[2])
+	})
+	for i, span := range spans {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), span, 1)
+	}
+	return text
+}
+
+// stripInline removes the inline markers of text that gets its own style.
+func stripInline(text string) string {
+	text = strings.NewReplacer("**", "", "__", "", "`", "").Replace(text)
+	return linkRegex.ReplaceAllString(text, "$1")
+}
+
+func visibleWidth(s string) int {
+	return runewidth.StringWidth(ansiRegex.ReplaceAllString(s, ""))
+}
+
+// wrapStyled wraps text containing ANSI styles to width visible cells.
+func wrapStyled(text string, width int) []string {
+	if width < 10 {
+		width = 10
+	}
+	var lines []string
+	line, lineWidth := "", 0
+	for _, word := range strings.SplitAfter(text, " ") {
+		wordWidth := visibleWidth(word)
+		if lineWidth+wordWidth > width && lineWidth > 0 {
+			lines = append(lines, strings.TrimRight(line, " "))
+			line, lineWidth = "", 0
+		}
+		line += word
+		lineWidth += wordWidth
+	}
+	return append(lines, strings.TrimRight(line, " "))
+}
+
+// codeLanguage describes enough of a language to highlight single lines.
+type codeLanguage struct {
+	keywords map[string]bool
+	comment  string
+}
+
+func words(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+	return set
+}
+
+var codeLanguages = map[string]codeLanguage{
+	"go":         {words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false"), "//"},
+	"python":     {words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False self"), "#"},
+	"javascript": {words("async await break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new of return super switch this throw try typeof var void while yield null undefined true false"), "//"},
+	"typescript": {words("async await break case catch class const continue default do else enum export extends finally for function if implements import in interface let new of private protected public readonly return static super switch this throw try type typeof var while null undefined true false"), "//"},
+	"java":       {words("abstract boolean break byte case catch char class continue default do double else enum extends final finally float for if implements import instanceof int interface long new package private protected public return short static super switch this throw throws try void while null true false"), "//"},
+	"c":          {words("auto break case char const continue default do double else enum extern float for goto if int long register return short signed sizeof static struct switch typedef union unsigned void volatile while NULL"), "//"},
+	"cpp":        {words("auto bool break case catch char class const constexpr continue default delete do double else enum explicit float for if int long namespace new nullptr private protected public return short static struct switch template this throw try typedef typename using virtual void while true false"), "//"},
+	"rust":       {words("as async await break const continue crate else enum fn for if impl in let loop match mod move mut pub ref return self Self static struct trait type unsafe use where while true false"), "//"},
+	"shell":      {words("if then else elif fi for while until do done case esac in function return export local echo exit"), "#"},
+	"sql":        {words("SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT AS NULL IS IN select from where and or not insert into values update set delete create table drop alter join left right inner outer on group by order having limit as null is in"), "--"},
+	"yaml":       {words("true false null yes no"), "#"},
+	"json":       {words("true false null"), ""},
+}
+
+var languageAliases = map[string]string{
+	"golang": "go", "py": "python", "js": "javascript", "jsx": "javascript", "ts": "typescript", "tsx": "typescript",
+	"h": "c", "c++": "cpp", "cc": "cpp", "hpp": "cpp", "rs": "rust", "sh": "shell", "bash": "shell", "zsh": "shell",
+	"console": "shell", "yml": "yaml", "kotlin": "java", "kt": "java", "csharp": "java", "cs": "java",
+}
+
+var codeTokenRegex = regexp.MustCompile("\"(?:[^\"\\\\]|\\\\.)*\"?|'(?:[^'\\\\]|\\\\.)*'?|`[^`]*`?|\\b\\d[\\d_.xXa-fA-F]*\\b|[A-Za-z_][A-Za-z0-9_]*")
+
+// highlightCode colors keywords, strings, numbers and comments of one line.
+func highlightCode(line string, lang string) string {
+	if alias, ok := languageAliases[lang]; ok {
+		lang = alias
+	}
+	language, ok := codeLanguages[lang]
+	if !ok {
+		return line
+	}
+
+	code, comment := line, ""
+	if language.comment != "" {
+		if i := commentStart(line, language.comment); i >= 0 {
+			code, comment = line[:i], line[i:]
+		}
+	}
+
+	code = codeTokenRegex.ReplaceAllStringFunc(code, func(token string) string {
+		switch c := token[0]; {
+		case c == '"' || c == '\'' || c == '`':
+			return stringStyle.Sprint(token)
+		case c >= '0' && c <= '9':
+			return numberStyle.Sprint(token)
+		case language.keywords[token]:
+			return keywordStyle.Sprint(token)
+		}
+		return token
+	})
+	if comment != "" {
+		comment = commentStyle.Sprint(comment)
+	}
+	return code + comment
+}
+
+// commentStart finds the line comment marker outside of string literals.
+func commentStart(line, marker string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case strings.HasPrefix(line[i:], marker):
+			return i
+		}
+	}
+	return -1
+}
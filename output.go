@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Output formats of --output.
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+// outputFormat selects how replies are printed. eventOutput receives the
+// JSON output, it stays the real stdout when os.Stdout is redirected.
+var (
+	outputFormat = outputText
+	eventOutput  = os.Stdout
+)
+
+func validOutputFormat(format string) bool {
+	switch format {
+	case outputText, outputJSON, outputNDJSON:
+		return true
+	}
+	return false
+}
+
+// jsonReply is the object printed for each request by --output json.
+type jsonReply struct {
+	Reply        string `json:"reply"`
+	Model        string `json:"model,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Usage        *Usage `json:"usage,omitempty"`
+	LatencyMs    int64  `json:"latency_ms"`
+	FirstTokenMs int64  `json:"first_token_ms"`
+	Session      string `json:"session,omitempty"`
+	Line         int    `json:"line,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// newJSONReply describes a reply to input.
+func newJSONReply(input *Messages, reply *Reply, memory string) jsonReply {
+	model := reply.Model
+	if model == "" {
+		model = input.Model
+	}
+	return jsonReply{
+		Reply:        reply.Text,
+		Model:        model,
+		Provider:     reply.Provider,
+		FinishReason: reply.FinishReason,
+		Usage:        &reply.Usage,
+		LatencyMs:    reply.Latency.Milliseconds(),
+		FirstTokenMs: reply.FirstToken.Milliseconds(),
+		Session:      sessionName(memory),
+	}
+}
+
+// jsonLine returns v as a single line of JSON.
+func jsonLine(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ""
+	}
+	return string(data) + "\n"
+}
+
+// printJSON prints v as a single line of JSON.
+func printJSON(v interface{}) {
+	eventOutput.WriteString(jsonLine(v))
+}
+
+// getJSON answers the conversation like getData and prints the reply with its
+// metadata as one JSON object. Failures are printed as an object with an
+// error so that the output stays parseable, and end the program.
+func getJSON(input *Messages, memory string) string {
+	fitContext(input)
+	reply, err := requestData(input, nil)
+	if err != nil {
+		printJSON(jsonReply{Model: input.Model, Session: sessionName(memory), Error: err.Error()})
+		keepRunningJob()
+		os.Exit(1)
+	}
+	input.Provider = reply.Provider
+	input.lastReply = reply
+	printJSON(newJSONReply(input, reply, memory))
+	return reply.Text
+}
+
+// streamEvent is a line printed by --output ndjson. Type is one of start,
+// delta, finish, usage and error.
+type streamEvent struct {
+	Type         string `json:"type"`
+	Text         string `json:"text,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+	Session      string `json:"session,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	LatencyMs    int64  `json:"latency_ms,omitempty"`
+	FirstTokenMs int64  `json:"first_token_ms,omitempty"`
+	Usage        *Usage `json:"usage,omitempty"`
+	Line         int    `json:"line,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// getNDJSON answers the conversation like getData and prints the answer as a
+// stream of events. Unlike getData it reports failures to the caller, after
+// an error event.
+func getNDJSON(input *Messages, memory string) (string, error) {
+	session := sessionName(memory)
+	printJSON(streamEvent{Type: "start", Model: input.Model, Session: session})
+
+	fitContext(input)
+	reply, err := requestData(input, func(text string) {
+		if text != "" {
+			printJSON(streamEvent{Type: "delta", Text: text})
+		}
+	})
+	if err != nil {
+		printJSON(streamEvent{Type: "error", Error: err.Error()})
+		return "", err
+	}
+	input.Provider = reply.Provider
+	input.lastReply = reply
+	for _, event := range finishEvents(input, reply, session) {
+		printJSON(event)
+	}
+	return reply.Text, nil
+}
+
+// finishEvents returns the events that end a successful reply.
+func finishEvents(input *Messages, reply *Reply, session string) []streamEvent {
+	model := reply.Model
+	if model == "" {
+		model = input.Model
+	}
+	return []streamEvent{{
+		Type:         "finish",
+		Model:        model,
+		Provider:     reply.Provider,
+		Session:      session,
+		FinishReason: reply.FinishReason,
+		LatencyMs:    reply.Latency.Milliseconds(),
+		FirstTokenMs: reply.FirstToken.Milliseconds(),
+	}, {Type: "usage", Usage: &reply.Usage}}
+}
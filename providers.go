@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const defaultProviderURL = "https://gpt.s-stars.top/v1/chat/completions"
+
+// Provider is a chat completions endpoint that can answer a request.
+type Provider struct {
+	Name    string
+	URL     string
+	AuthKey string
+	// Model replaces the requested model when not empty.
+	Model string
+}
+
+// providerChain is tried in order until one of the providers answers.
+var providerChain = []Provider{{Name: "default", URL: defaultProviderURL}}
+
+// providers holds every configured provider by name, in the chain or not.
+var providers = map[string]Provider{"default": providerChain[0]}
+
+// verbose prints which provider answered and why others were skipped.
+var verbose bool
+
+// statusError is returned when the endpoint answers with an error status.
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.code, e.body)
+}
+
+// shouldFailover reports whether the next provider should be tried after err.
+// Network errors, server errors and rate limits fail over, other client
+// errors would fail the same way everywhere.
+func shouldFailover(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == 429 || se.code >= 500
+	}
+	return true
+}
+
+// loadProviders builds the provider chain from the PROVIDERS and CHAIN config keys.
+//
+//	"PROVIDERS": {"backup": {"URL": "https://...", "AUTH_KEY": "...", "MODEL": "..."}},
+//	"CHAIN": ["default", "backup"]
+func loadProviders(configData map[string]interface{}) {
+	providers = map[string]Provider{
+		"default": {Name: "default", URL: defaultProviderURL},
+	}
+	if configured, ok := configData["PROVIDERS"].(map[string]interface{}); ok {
+		for name, value := range configured {
+			settings, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			provider := Provider{Name: name}
+			provider.URL, _ = settings["URL"].(string)
+			provider.AuthKey, _ = settings["AUTH_KEY"].(string)
+			provider.Model, _ = settings["MODEL"].(string)
+			if provider.URL == "" {
+				provider.URL = defaultProviderURL
+			}
+			providers[name] = provider
+		}
+	}
+
+	var names []string
+	if chain, ok := configData["CHAIN"].([]interface{}); ok {
+		for _, name := range chain {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "default")
+		for name := range providers {
+			if name != "default" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names[1:])
+	}
+
+	providerChain = providerChain[:0]
+	for _, name := range names {
+		provider, ok := providers[name]
+		if !ok {
+			warnColor.Fprintf(os.Stderr, "CHAIN: unknown provider %s\n", name)
+			continue
+		}
+		providerChain = append(providerChain, provider)
+	}
+	if len(providerChain) == 0 {
+		providerChain = append(providerChain, providers["default"])
+	}
+}
+
+// findProvider returns the provider with the given name, configured in
+// PROVIDERS whether it is in the chain or not.
+func findProvider(name string) (Provider, bool) {
+	provider, ok := providers[name]
+	return provider, ok
+}
+
+// preferProvider moves the named provider to the front of the chain.
+func preferProvider(name string) error {
+	provider, ok := findProvider(name)
+	if !ok {
+		return fmt.Errorf("unknown provider: %s", name)
+	}
+	chain := []Provider{provider}
+	for _, p := range providerChain {
+		if p.Name != name {
+			chain = append(chain, p)
+		}
+	}
+	providerChain = chain
+	return nil
+}
+
+// requestChain asks the providers in order and returns the first answer.
+// A provider that already streamed text is not replaced, the output would
+// be garbled.
+func requestChain(chain []Provider, input *Messages, callback func(string)) (*Reply, error) {
+	var errs []string
+	for _, provider := range chain {
+		streamed := false
+		reply, err := requestProvider(provider, input, func(s string) {
+			if s != "" {
+				streamed = true
+			}
+			if callback != nil {
+				callback(s)
+			}
+		})
+		if err == nil {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "\nanswered by %s (%s)\n", provider.Name, reply.Model)
+			}
+			return reply, nil
+		}
+		errs = append(errs, provider.Name+": "+err.Error())
+		if streamed || !shouldFailover(err) {
+			break
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "\r%s failed: %v\n", provider.Name, err)
+		}
+	}
+	return nil, errors.New(strings.Join(errs, "\n"))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// retrieveChunks is the number of chunks of a long block sent instead of
+// summarizing the block, zero summarizes it.
+var retrieveChunks int
+
+// BM25 parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var termRegex = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+
+// searchTerms splits text into lower case words. Runs of CJK characters have
+// no spaces, they give their characters and pairs of characters instead.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, word := range termRegex.FindAllString(strings.ToLower(text), -1) {
+		r, _ := utf8.DecodeRuneInString(word)
+		if !isWide(r) {
+			terms = append(terms, word)
+			continue
+		}
+		runes := []rune(word)
+		for i := range runes {
+			terms = append(terms, string(runes[i]))
+			if i+1 < len(runes) {
+				terms = append(terms, string(runes[i:i+2]))
+			}
+		}
+	}
+	return terms
+}
+
+// chunkIndex is an in-memory BM25 index over the chunks of a block.
+type chunkIndex struct {
+	frequencies []map[string]int
+	lengths     []int
+	documents   map[string]int
+	average     float64
+}
+
+func newChunkIndex(chunks []string) *chunkIndex {
+	index := &chunkIndex{documents: map[string]int{}}
+	total := 0
+	for _, chunk := range chunks {
+		frequency := map[string]int{}
+		terms := searchTerms(chunk)
+		for _, term := range terms {
+			frequency[term]++
+		}
+		for term := range frequency {
+			index.documents[term]++
+		}
+		index.frequencies = append(index.frequencies, frequency)
+		index.lengths = append(index.lengths, len(terms))
+		total += len(terms)
+	}
+	if len(chunks) > 0 {
+		index.average = float64(total) / float64(len(chunks))
+	}
+	return index
+}
+
+// score returns the BM25 score of every chunk for query.
+func (index *chunkIndex) score(query string) []float64 {
+	scores := make([]float64, len(index.frequencies))
+	n := float64(len(index.frequencies))
+	seen := map[string]bool{}
+	for _, term := range searchTerms(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		df := float64(index.documents[term])
+		if df == 0 {
+			continue
+		}
+		idf := math.Log((n-df+0.5)/(df+0.5) + 1)
+		for i, frequency := range index.frequencies {
+			tf := float64(frequency[term])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(index.lengths[i])/index.average
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// retrieveBlock returns the k chunks of text most relevant to prompt, in the
+// order of the block and labeled with their position, as many as fit the
+// budget of model. A block that fits is returned as it is.
+func retrieveBlock(text, prompt, model string, k int) string {
+	budget := blockBudget(model)
+	if countTokens(text) <= budget {
+		return text
+	}
+
+	// Chunks do not overlap here, so that their lines can be told.
+	chunks := splitChunks(text, chunkSize, 0)
+	lines := make([]int, len(chunks)+1)
+	lines[0] = 1
+	for i, chunk := range chunks {
+		lines[i+1] = lines[i] + strings.Count(chunk, "\n")
+	}
+
+	scores := newChunkIndex(chunks).score(prompt)
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	if scores[order[0]] == 0 {
+		warnColor.Fprintln(os.Stderr, "no chunk matches the prompt, sending the first chunks")
+		sort.Ints(order)
+	}
+
+	var selected []int
+	tokens := 0
+	for _, i := range order {
+		if len(selected) == k || (scores[order[0]] > 0 && scores[i] == 0) {
+			break
+		}
+		n := countTokens(chunks[i])
+		if len(selected) > 0 && tokens+n > budget {
+			warnColor.Fprintf(os.Stderr, "only %d of the %d chunks asked for fit the context\n", len(selected), k)
+			break
+		}
+		selected = append(selected, i)
+		tokens += n
+	}
+	sort.Ints(selected)
+
+	var excerpts strings.Builder
+	fmt.Fprintf(&excerpts, "The input is %d chunks long, these are the %d most relevant to the question.\n", len(chunks), len(selected))
+	for _, i := range selected {
+		end := lines[i+1]
+		if strings.HasSuffix(chunks[i], "\n") {
+			end--
+		}
+		fmt.Fprintf(&excerpts, "\n[chunk %d of %d, lines %d-%d]\n%s\n", i+1, len(chunks), lines[i], end, strings.TrimRight(chunks[i], "\n"))
+	}
+	return excerpts.String()
+}
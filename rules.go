@@ -0,0 +1,197 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed code.rule
+var codeRule string
+
+//go:embed proc.rule
+var procRule string
+
+// builtinRules are always available and can be shadowed by rules of the same
+// name in the SYSTEM config map.
+var builtinRules = map[string]string{
+	"code": codeRule,
+	"proc": procRule,
+	"shell": "Your Role: Provide only shell commands as output without any description.\n" +
+		"IMPORTANT: Provide only plain text without Markdown formatting.\n" +
+		"If multiple steps required try to combine them together.\n" +
+		"If there is a lack of details, provide most logical solution. You are not allowed to ask for more details.",
+	"summary": "Your Role: Summarize the given content.\n" +
+		"Keep the key facts, names and numbers, leave out examples and repetitions.\n" +
+		"Answer in the language of the content.",
+	"translate": "Your Role: Translate the given text into English, or into Chinese when it is already English.\n" +
+		"Provide only the translation without any description.",
+	"explain": "Your Role: Explain the given code or concept step by step to a beginner.\n" +
+		"Start with a one sentence overview, then go into details.",
+}
+
+// resolveRule returns the rule text for value. Names are looked up in the
+// SYSTEM config map and the built-in rules, anything else is read as a file
+// or used as the rule text itself.
+func resolveRule(configData map[string]interface{}, value string) string {
+	if library, ok := configData["SYSTEM"].(map[string]interface{}); ok {
+		if rule, ok := library[value].(string); ok {
+			return rule
+		}
+	}
+	if rule, ok := builtinRules[value]; ok {
+		return rule
+	}
+	return tryReadContent(value)
+}
+
+// identityRule introduces the user and the AI by name when they are set.
+const identityRule = `{{if .AIName}}You name is {{.AIName}}
+{{end}}{{if .UserName}}User name is {{.UserName}}
+{{end}}`
+
+// ruleVars returns the variables available to rule templates. Custom
+// variables are given as key=value and override the built-in ones.
+func ruleVars(userName, aiName string, vars []string) (map[string]interface{}, error) {
+	cwd, _ := os.Getwd()
+	data := map[string]interface{}{
+		"Date":     time.Now().Format("2006-01-02"),
+		"Cwd":      cwd,
+		"OS":       runtime.GOOS,
+		"Shell":    currentShell(),
+		"UserName": userName,
+		"AIName":   aiName,
+	}
+	for _, v := range vars {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid variable, expected key=value: %s", v)
+		}
+		data[key] = value
+	}
+	return data, nil
+}
+
+// renderRule executes rule as a Go template. Rules that are not valid
+// templates are used as they are.
+func renderRule(rule string, data map[string]interface{}) string {
+	if !strings.Contains(rule, "{{") {
+		return rule
+	}
+	tmpl, err := template.New("rule").Funcs(template.FuncMap{"env": os.Getenv}).Parse(rule)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rule is not a valid template:", err)
+		return rule
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		fmt.Fprintln(os.Stderr, "rule is not a valid template:", err)
+		return rule
+	}
+	return rendered.String()
+}
+
+// systemVars are the variables the system rule was rendered with, /system
+// renders its rule with them as well.
+var systemVars = map[string]interface{}{}
+
+// buildSystemRule resolves every rule, renders it and joins them in order
+// after the identity rule.
+func buildSystemRule(configData map[string]interface{}, rules []string, data map[string]interface{}) string {
+	var parts []string
+	if identity := strings.TrimSpace(renderRule(identityRule, data)); identity != "" {
+		parts = append(parts, identity)
+	}
+	for _, rule := range rules {
+		if rule == "" {
+			continue
+		}
+		parts = append(parts, strings.TrimSpace(renderRule(resolveRule(configData, rule), data)))
+	}
+	return strings.Join(parts, "\n")
+}
+
+func rulesCommand(configManager *ConfigManager, configData map[string]interface{}, args []string) {
+	library, ok := configData["SYSTEM"].(map[string]interface{})
+	if !ok {
+		library = map[string]interface{}{}
+		configData["SYSTEM"] = library
+	}
+
+	if len(args) == 0 {
+		printRulesUsage()
+		os.Exit(-1)
+	}
+
+	command, args := args[0], args[1:]
+	switch {
+	case command == "list" && len(args) == 0:
+		names := map[string]string{}
+		for name := range builtinRules {
+			names[name] = "builtin"
+		}
+		for name := range library {
+			names[name] = "user"
+		}
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		table := [][]string{{"NAME", "SOURCE", "RULE"}}
+		for _, name := range sorted {
+			rule := strings.TrimSpace(resolveRule(configData, name))
+			if i := strings.Index(rule, "\n"); i >= 0 {
+				rule = rule[:i] + " ..."
+			}
+			table = append(table, []string{name, names[name], rule})
+		}
+		printTable(table)
+	case command == "show" && len(args) == 1:
+		if _, ok := library[args[0]]; !ok {
+			if _, ok := builtinRules[args[0]]; !ok {
+				fmt.Println("unknown rule:", args[0])
+				os.Exit(-1)
+			}
+		}
+		fmt.Println(resolveRule(configData, args[0]))
+	case command == "add" && len(args) == 2:
+		rule := tryReadContent(args[1])
+		if rule == "" {
+			fmt.Println("empty rule:", args[1])
+			os.Exit(-1)
+		}
+		library[args[0]] = rule
+		if err := configManager.WriteConfig(configData); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	case command == "remove" && len(args) == 1:
+		if _, ok := library[args[0]]; !ok {
+			fmt.Println("unknown user rule:", args[0])
+			os.Exit(-1)
+		}
+		delete(library, args[0])
+		if err := configManager.WriteConfig(configData); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	default:
+		printRulesUsage()
+		os.Exit(-1)
+	}
+}
+
+func printRulesUsage() {
+	fmt.Println("USAGE:")
+	fmt.Println("  tgpt rules list")
+	fmt.Println("  tgpt rules show <name>")
+	fmt.Println("  tgpt rules add <name> <file|text>")
+	fmt.Println("  tgpt rules remove <name>")
+}
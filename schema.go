@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// schemaFile is the JSON Schema replies must be valid against and
+// schemaRetries the number of times an invalid reply is asked again.
+// replySchema is the loaded schema, nil when replies are free text.
+var (
+	schemaFile    string
+	schemaRetries = 2
+	replySchema   *Schema
+)
+
+// defaultSchemaPrompt is used when --schema is given without a prompt.
+const defaultSchemaPrompt = "Extract the data from the content above."
+
+// Schema is a JSON Schema. The keywords of draft 7 that describe the shape
+// of data are supported, annotations and formats are ignored.
+type Schema struct {
+	root interface{}
+	text string
+	// resolving holds the references followed at a path of the value, a
+	// reference met again there without going deeper is a cycle.
+	resolving map[string]bool
+}
+
+// loadSchema reads a schema from a file or the text of the flag.
+func loadSchema(value string) (*Schema, error) {
+	text := tryReadContent(value)
+	var root interface{}
+	if err := json.Unmarshal([]byte(text), &root); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %v", value, err)
+	}
+	if _, ok := root.(map[string]interface{}); !ok {
+		if _, ok := root.(bool); !ok {
+			return nil, fmt.Errorf("invalid schema %s: not an object", value)
+		}
+	}
+	return &Schema{root: root, text: strings.TrimSpace(text)}, nil
+}
+
+// rule returns the system rule asking for replies valid against the schema.
+func (s *Schema) rule() string {
+	return "Reply only with a JSON value that is valid against the following JSON Schema, " +
+		"without Markdown, code fences or explanations.\n" + s.text
+}
+
+// Validate returns the violations of the schema by value, empty when valid.
+func (s *Schema) Validate(value interface{}) []string {
+	s.resolving = map[string]bool{}
+	return s.validate(s.root, value, "$")
+}
+
+func (s *Schema) validate(schema interface{}, value interface{}, path string) []string {
+	if allowed, ok := schema.(bool); ok {
+		if !allowed {
+			return []string{path + ": no value is allowed"}
+		}
+		return nil
+	}
+	rules, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if ref, ok := rules["$ref"].(string); ok {
+		key := path + " " + ref
+		if s.resolving[key] {
+			return []string{path + ": circular reference: " + ref}
+		}
+		target, err := s.resolveRef(ref)
+		if err != nil {
+			return []string{path + ": " + err.Error()}
+		}
+		s.resolving[key] = true
+		defer delete(s.resolving, key)
+		return s.validate(target, value, path)
+	}
+
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types, ok := rules["type"]; ok && !matchesType(types, value) {
+		fail("expected %s, got %s", typeNames(types), jsonType(value))
+		return errs
+	}
+	if enum, ok := rules["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if jsonEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compactJSON(enum))
+		}
+	}
+	if constant, ok := rules["const"]; ok && !jsonEqual(constant, value) {
+		fail("must be %s", compactJSON(constant))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if min, ok := rules["minLength"].(float64); ok && float64(length) < min {
+			fail("shorter than %v characters", min)
+		}
+		if max, ok := rules["maxLength"].(float64); ok && float64(length) > max {
+			fail("longer than %v characters", max)
+		}
+		if pattern, ok := rules["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("does not match %s", pattern)
+			}
+		}
+	case float64:
+		if min, ok := rules["minimum"].(float64); ok && v < min {
+			fail("less than %v", min)
+		}
+		if max, ok := rules["maximum"].(float64); ok && v > max {
+			fail("greater than %v", max)
+		}
+		if min, ok := rules["exclusiveMinimum"].(float64); ok && v <= min {
+			fail("not greater than %v", min)
+		}
+		if max, ok := rules["exclusiveMaximum"].(float64); ok && v >= max {
+			fail("not less than %v", max)
+		}
+		if step, ok := rules["multipleOf"].(float64); ok && step > 0 {
+			if quotient := v / step; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+				fail("not a multiple of %v", step)
+			}
+		}
+	case []interface{}:
+		if min, ok := rules["minItems"].(float64); ok && float64(len(v)) < min {
+			fail("fewer than %v items", min)
+		}
+		if max, ok := rules["maxItems"].(float64); ok && float64(len(v)) > max {
+			fail("more than %v items", max)
+		}
+		if unique, ok := rules["uniqueItems"].(bool); ok && unique {
+			for i := range v {
+				for j := i + 1; j < len(v); j++ {
+					if jsonEqual(v[i], v[j]) {
+						fail("items %d and %d are equal", i, j)
+					}
+				}
+			}
+		}
+		switch items := rules["items"].(type) {
+		case []interface{}:
+			for i, item := range v {
+				if i < len(items) {
+					errs = append(errs, s.validate(items[i], item, fmt.Sprintf("%s[%d]", path, i))...)
+				} else if additional, ok := rules["additionalItems"]; ok {
+					errs = append(errs, s.validate(additional, item, fmt.Sprintf("%s[%d]", path, i))...)
+				}
+			}
+		case nil:
+		default:
+			for i, item := range v {
+				errs = append(errs, s.validate(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := rules["required"].([]interface{}); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, found := v[key]; !found {
+						fail("missing required property %q", key)
+					}
+				}
+			}
+		}
+		if min, ok := rules["minProperties"].(float64); ok && float64(len(v)) < min {
+			fail("fewer than %v properties", min)
+		}
+		if max, ok := rules["maxProperties"].(float64); ok && float64(len(v)) > max {
+			fail("more than %v properties", max)
+		}
+		properties, _ := rules["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "." + key
+			if property, ok := properties[key]; ok {
+				errs = append(errs, s.validate(property, v[key], child)...)
+			} else if additional, ok := rules["additionalProperties"]; ok {
+				if allowed, ok := additional.(bool); ok && !allowed {
+					fail("unexpected property %q", key)
+				} else {
+					errs = append(errs, s.validate(additional, v[key], child)...)
+				}
+			}
+		}
+	}
+
+	if all, ok := rules["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			errs = append(errs, s.validate(sub, value, path)...)
+		}
+	}
+	if anyOf, ok := rules["anyOf"].([]interface{}); ok {
+		valid := false
+		for _, sub := range anyOf {
+			if len(s.validate(sub, value, path)) == 0 {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			fail("not valid against any schema of anyOf")
+		}
+	}
+	if one, ok := rules["oneOf"].([]interface{}); ok {
+		valid := 0
+		for _, sub := range one {
+			if len(s.validate(sub, value, path)) == 0 {
+				valid++
+			}
+		}
+		if valid != 1 {
+			fail("valid against %d schemas of oneOf instead of one", valid)
+		}
+	}
+	if not, ok := rules["not"]; ok && len(s.validate(not, value, path)) == 0 {
+		fail("must not be valid against the schema of not")
+	}
+	return errs
+}
+
+// resolveRef finds a local reference such as #/definitions/address.
+func (s *Schema) resolveRef(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("only local references are supported: %s", ref)
+	}
+	current := s.root
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolved reference: %s", ref)
+		}
+		if current, ok = object[part]; !ok {
+			return nil, fmt.Errorf("unresolved reference: %s", ref)
+		}
+	}
+	return current, nil
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func matchesType(types interface{}, value interface{}) bool {
+	actual := jsonType(value)
+	match := func(name interface{}) bool {
+		return name == actual || (name == "number" && actual == "integer")
+	}
+	if list, ok := types.([]interface{}); ok {
+		for _, name := range list {
+			if match(name) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(types)
+}
+
+func typeNames(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, len(list))
+		for i, name := range list {
+			names[i] = fmt.Sprint(name)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// parseJSONReply reads the JSON value of a reply, fenced or not, and
+// returns it along with its compacted text.
+func parseJSONReply(reply string) (interface{}, string, error) {
+	text := strings.TrimSpace(reply)
+	if blocks := extractCodeBlocks(text); len(blocks) > 0 {
+		text = blocks[0].Code
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, "", fmt.Errorf("the reply is not valid JSON: %v", err)
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, []byte(text))
+	return value, compact.String(), nil
+}
+
+// getValidJSON answers the conversation and checks the reply against the
+// schema, asking again with the violations up to schemaRetries times. The
+// failed attempts are not kept in the conversation.
+func getValidJSON(input *Messages, schema *Schema) (string, error) {
+	attempt := input.CloneMessages()
+	for i := 0; ; i++ {
+		fitContext(attempt)
+		reply, err := requestData(attempt, nil)
+		if err != nil {
+			return "", err
+		}
+		input.Provider = reply.Provider
+		input.lastReply = reply
+
+		var problems []string
+		value, text, err := parseJSONReply(reply.Text)
+		if err != nil {
+			problems = []string{err.Error()}
+		} else {
+			problems = schema.Validate(value)
+		}
+		if len(problems) == 0 {
+			return text, nil
+		}
+
+		if i >= schemaRetries {
+			fmt.Fprintln(os.Stderr, reply.Text)
+			return "", errors.New("the reply is not valid against the schema:\n  " + strings.Join(problems, "\n  "))
+		}
+		warnColor.Fprintf(os.Stderr, "invalid reply, asking again (%d of %d)\n", i+1, schemaRetries)
+		attempt.AddAssistantMessage(getSafeString(reply.Text))
+		attempt.AddUserMessage(getSafeString("Your reply is not valid against the JSON Schema:\n- " + strings.Join(problems, "\n- ") +
+			"\nReply again with corrected JSON only."))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		errors []string
+	}{
+		{"type", `{"type": "string"}`, `"a"`, nil},
+		{"type mismatch", `{"type": "string"}`, `1`, []string{"$: expected string, got integer"}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"integer is a number", `{"type": "number"}`, `3`, nil},
+		{"number is not an integer", `{"type": "integer"}`, `3.5`, []string{"$: expected integer, got number"}},
+		{"enum", `{"enum": ["a", "b"]}`, `"b"`, nil},
+		{"enum mismatch", `{"enum": ["a", "b"]}`, `"c"`, []string{`$: must be one of ["a","b"]`}},
+		{"const", `{"const": {"a": 1}}`, `{"a": 1}`, nil},
+		{"const mismatch", `{"const": 1}`, `2`, []string{"$: must be 1"}},
+		{"true schema", `true`, `{"a": 1}`, nil},
+		{"false schema", `false`, `1`, []string{"$: no value is allowed"}},
+
+		{"minLength counts characters", `{"minLength": 2}`, `"日本"`, nil},
+		{"minLength", `{"minLength": 3}`, `"ab"`, []string{"$: shorter than 3 characters"}},
+		{"maxLength", `{"maxLength": 1}`, `"ab"`, []string{"$: longer than 1 characters"}},
+		{"pattern", `{"pattern": "^[0-9]+$"}`, `"123"`, nil},
+		{"pattern mismatch", `{"pattern": "^[0-9]+$"}`, `"12a"`, []string{"$: does not match ^[0-9]+$"}},
+
+		{"minimum", `{"minimum": 1}`, `0`, []string{"$: less than 1"}},
+		{"maximum", `{"maximum": 1}`, `1`, nil},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1`, []string{"$: not greater than 1"}},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 1}`, `1`, []string{"$: not less than 1"}},
+		{"multipleOf", `{"multipleOf": 0.1}`, `0.3`, nil},
+		{"multipleOf mismatch", `{"multipleOf": 2}`, `3`, []string{"$: not a multiple of 2"}},
+
+		{"minItems", `{"minItems": 2}`, `[1]`, []string{"$: fewer than 2 items"}},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, []string{"$: more than 1 items"}},
+		{"uniqueItems", `{"uniqueItems": true}`, `[1, 2, 1]`, []string{"$: items 0 and 2 are equal"}},
+		{"items", `{"items": {"type": "integer"}}`, `[1, "a"]`, []string{"$[1]: expected integer, got string"}},
+		{"tuple items", `{"items": [{"type": "string"}], "additionalItems": false}`, `["a", 1]`, []string{"$[1]: no value is allowed"}},
+
+		{"required", `{"required": ["a", "b"]}`, `{"a": 1}`, []string{`$: missing required property "b"`}},
+		{"minProperties", `{"minProperties": 1}`, `{}`, []string{"$: fewer than 1 properties"}},
+		{"maxProperties", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, []string{"$: more than 1 properties"}},
+		{"properties", `{"properties": {"a": {"type": "string"}}}`, `{"a": 1, "b": 2}`, []string{"$.a: expected string, got integer"}},
+		{"additionalProperties false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, []string{`$: unexpected property "b"`}},
+		{"additionalProperties schema", `{"additionalProperties": {"type": "integer"}}`, `{"a": 1, "b": "x"}`, []string{"$.b: expected integer, got string"}},
+
+		{"allOf", `{"allOf": [{"minimum": 1}, {"maximum": 3}]}`, `4`, []string{"$: greater than 3"}},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"minimum": 1}]}`, `2`, nil},
+		{"anyOf mismatch", `{"anyOf": [{"type": "string"}, {"minimum": 1}]}`, `0`, []string{"$: not valid against any schema of anyOf"}},
+		{"oneOf", `{"oneOf": [{"minimum": 1}, {"maximum": 3}]}`, `2`, []string{"$: valid against 2 schemas of oneOf instead of one"}},
+		{"not", `{"not": {"type": "null"}}`, `null`, []string{"$: must not be valid against the schema of not"}},
+
+		{"ref", `{"definitions": {"id": {"type": "integer"}}, "properties": {"id": {"$ref": "#/definitions/id"}}}`, `{"id": "x"}`, []string{"$.id: expected integer, got string"}},
+		{"unresolved ref", `{"$ref": "#/definitions/missing"}`, `1`, []string{"$: unresolved reference: #/definitions/missing"}},
+		{"circular ref", `{"definitions": {"a": {"$ref": "#/definitions/a"}}, "$ref": "#/definitions/a"}`, `1`, []string{"$: circular reference: #/definitions/a"}},
+		{"circular refs", `{"definitions": {"a": {"$ref": "#/definitions/b"}, "b": {"anyOf": [{"$ref": "#/definitions/a"}]}}, "$ref": "#/definitions/a"}`, `1`, []string{"$: not valid against any schema of anyOf"}},
+		{"recursive ref", `{"definitions": {"node": {"type": "object", "properties": {"next": {"$ref": "#/definitions/node"}}}}, "$ref": "#/definitions/node"}`, `{"next": {"next": 1}}`, []string{"$.next.next: expected object, got integer"}},
+		{"remote ref", `{"$ref": "other.json"}`, `1`, []string{"$: only local references are supported: other.json"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := loadSchema(test.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			errors := schema.Validate(value)
+			if strings.Join(errors, "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("Validate(%s) = %q, want %q", test.value, errors, test.errors)
+			}
+		})
+	}
+}
+
+func TestLoadSchemaInvalid(t *testing.T) {
+	for _, text := range []string{`{"type": `, `[1, 2]`, `"string"`} {
+		if _, err := loadSchema(text); err == nil {
+			t.Errorf("loadSchema(%s) did not fail", text)
+		}
+	}
+}
+
+func TestParseJSONReply(t *testing.T) {
+	tests := []struct {
+		reply string
+		text  string
+		fails bool
+	}{
+		{`{"a": 1}`, `{"a":1}`, false},
+		{"  [1, 2]\n", `[1,2]`, false},
+		{"```json\n{\"a\": [1, 2]}\n```", `{"a":[1,2]}`, false},
+		{"Here it is:\n```\n{\"a\": 1}\n```", `{"a":1}`, false},
+		{`{"a": }`, ``, true},
+		{`no json`, ``, true},
+	}
+	for _, test := range tests {
+		_, text, err := parseJSONReply(test.reply)
+		if (err != nil) != test.fails {
+			t.Errorf("parseJSONReply(%q) error = %v", test.reply, err)
+		}
+		if text != test.text {
+			t.Errorf("parseJSONReply(%q) = %s, want %s", test.reply, text, test.text)
+		}
+	}
+}
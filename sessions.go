@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// sessionsDir holds the memory files of named sessions. It can be moved with
+// the DIR key of the MEMORY config map.
+var sessionsDir string
+
+// memoryBudget is the token count above which a memory file is compacted,
+// zero means half the context window of the model. memoryKeepTurns is the
+// number of latest turns that are never compacted.
+var (
+	memoryBudget    int
+	memoryKeepTurns = 4
+)
+
+// loadMemoryConfig reads the MEMORY config map:
+//
+//	"MEMORY": {"DIR": "...", "TOKEN_BUDGET": 3000, "KEEP_TURNS": 4}
+func loadMemoryConfig(configDir string, configData map[string]interface{}) {
+	sessionsDir = filepath.Join(configDir, "gpt", "sessions")
+	memory, ok := configData["MEMORY"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if dir, ok := memory["DIR"].(string); ok && dir != "" {
+		sessionsDir = dir
+	}
+	if budget, ok := memory["TOKEN_BUDGET"].(float64); ok {
+		memoryBudget = int(budget)
+	}
+	if keep, ok := memory["KEEP_TURNS"].(float64); ok && keep >= 1 {
+		memoryKeepTurns = int(keep)
+	}
+}
+
+// isSessionName reports whether memory is a bare session name rather than
+// an explicit file path.
+func isSessionName(memory string) bool {
+	return !strings.ContainsAny(memory, `/\`) && filepath.Ext(memory) == ""
+}
+
+// sessionPath returns the memory file of a named session, paths are kept.
+func sessionPath(name string) string {
+	if !isSessionName(name) {
+		return name
+	}
+	return filepath.Join(sessionsDir, name+".json")
+}
+
+// resolveMemory maps the --memory value to a file. Bare names live in the
+// sessions directory, paths are used as given.
+func resolveMemory(memory string) string {
+	if memory == "" || !isSessionName(memory) {
+		return memory
+	}
+	path := sessionPath(memory)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// Older versions wrote bare names into the working directory.
+		if fileInfo, err := os.Stat(memory); canRead(err, fileInfo) {
+			return memory
+		}
+	}
+	os.MkdirAll(sessionsDir, 0755)
+	return path
+}
+
+// sessionName returns the name of the session stored in memory, the path
+// for memory files outside the sessions directory.
+func sessionName(memory string) string {
+	if memory != "" && filepath.Dir(memory) == filepath.Clean(sessionsDir) {
+		return strings.TrimSuffix(filepath.Base(memory), ".json")
+	}
+	return memory
+}
+
+// saveMemory compacts the conversation when needed and writes it to memory.
+func saveMemory(messages *Messages, memory string) error {
+	compactMemory(messages)
+	return messages.save(memory)
+}
+
+// summaryPrefix marks the system message that holds a summary of the older
+// turns, written by compactMemory or the summarize context policy.
+const summaryPrefix = "Conversation so far: "
+
+// isSummary reports whether message is a summary of older turns.
+func isSummary(message Message) bool {
+	return message.Role == "system" && strings.HasPrefix(message.Content, summaryPrefix)
+}
+
+// compactMemory replaces the oldest turns with a summary written by the model
+// once the conversation exceeds memoryBudget. The latest memoryKeepTurns
+// turns stay verbatim and the replaced messages move to the archive.
+func compactMemory(m *Messages) {
+	budget := memoryBudget
+	if budget <= 0 {
+		budget = contextSize(m.Model) / 2
+	}
+	if countMessagesTokens(m.Messages) <= budget {
+		return
+	}
+
+	// Turns start at every user message.
+	var turns []int
+	for i, message := range m.Messages {
+		if message.Role == "user" {
+			turns = append(turns, i)
+		}
+	}
+	if len(turns) <= memoryKeepTurns {
+		return
+	}
+	end := turns[len(turns)-memoryKeepTurns]
+
+	var head, old []Message
+	var previous []Message
+	for _, message := range m.Messages[:end] {
+		switch {
+		case isSummary(message):
+			previous = append(previous, message)
+		case message.Role == "system":
+			head = append(head, message)
+		default:
+			old = append(old, message)
+		}
+	}
+	if len(old) == 0 {
+		return
+	}
+
+	summary := summarizeMessages(m.Model, append(previous, old...), budget/4)
+	if summary == "" {
+		return
+	}
+
+	messages := append(head, Message{Role: "system", Content: summaryPrefix + summary})
+	m.Messages = append(messages, m.Messages[end:]...)
+	m.Archive = append(m.Archive, old...)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "memory: compacted %d messages into a summary\n", len(old))
+	}
+}
+
+func sessionsCommand(configManager *ConfigManager, configData map[string]interface{}, args []string) {
+	if len(args) == 0 {
+		printSessionsUsage()
+		os.Exit(-1)
+	}
+
+	command, args := args[0], args[1:]
+	var err error
+	switch {
+	case command == "list" && len(args) == 0:
+		err = listSessions()
+	case command == "show" && len(args) == 1:
+		err = showSession(args[0])
+	case command == "rename" && len(args) == 2:
+		err = renameSession(args[0], args[1])
+	case command == "title" && len(args) == 2:
+		err = updateSession(args[0], func(m *Messages) error {
+			m.Title = args[1]
+			return nil
+		})
+	case command == "tag" && len(args) >= 2:
+		err = updateSession(args[0], func(m *Messages) error {
+			m.Tags = mergeTags(m.Tags, args[1:])
+			return nil
+		})
+	case command == "fork":
+		err = forkSession(args)
+	case command == "branches" && len(args) == 1:
+		messages := NewMessages()
+		if err = messages.load(sessionPath(args[0])); err == nil {
+			fmt.Println(messages.branchTree())
+		}
+	case command == "switch" && len(args) == 2:
+		err = updateSession(args[0], func(m *Messages) error {
+			return m.switchBranch(args[1])
+		})
+	case command == "delete" && len(args) == 1:
+		err = os.Remove(sessionPath(args[0]))
+	case command == "path" && len(args) <= 1:
+		if len(args) == 0 {
+			fmt.Println(sessionsDir)
+		} else {
+			fmt.Println(sessionPath(args[0]))
+		}
+	default:
+		printSessionsUsage()
+		os.Exit(-1)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func printSessionsUsage() {
+	fmt.Println("USAGE:")
+	fmt.Println("  tgpt sessions list")
+	fmt.Println("  tgpt sessions show <name>")
+	fmt.Println("  tgpt sessions rename <name> <new name>")
+	fmt.Println("  tgpt sessions title <name> <title>")
+	fmt.Println("  tgpt sessions tag <name> <tag> [tag...]")
+	fmt.Println("  tgpt sessions fork <name> --at <message> [branch]")
+	fmt.Println("  tgpt sessions branches <name>")
+	fmt.Println("  tgpt sessions switch <name> <branch>")
+	fmt.Println("  tgpt sessions delete <name>")
+	fmt.Println("  tgpt sessions path [name]")
+}
+
+func listSessions() error {
+	entries, err := os.ReadDir(sessionsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	type session struct {
+		name     string
+		title    string
+		tags     []string
+		lastUsed time.Time
+		count    int
+		model    string
+	}
+	var sessions []session
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		messages := NewMessages()
+		if err := messages.load(filepath.Join(sessionsDir, entry.Name())); err != nil {
+			continue
+		}
+		lastUsed := info.ModTime()
+		if messages.Updated != nil {
+			lastUsed = *messages.Updated
+		}
+		sessions = append(sessions, session{
+			name:     strings.TrimSuffix(entry.Name(), ".json"),
+			title:    messages.Title,
+			tags:     messages.Tags,
+			lastUsed: lastUsed,
+			count:    len(messages.Messages),
+			model:    messages.Model,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].lastUsed.After(sessions[j].lastUsed)
+	})
+
+	table := [][]string{{"NAME", "LAST USED", "MESSAGES", "MODEL", "TAGS", "TITLE"}}
+	for _, s := range sessions {
+		table = append(table, []string{s.name, s.lastUsed.Format("2006-01-02 15:04"), fmt.Sprint(s.count), s.model, strings.Join(s.tags, ","), s.title})
+	}
+	printTable(table)
+	return nil
+}
+
+func showSession(name string) error {
+	messages := NewMessages()
+	if err := messages.load(sessionPath(name)); err != nil {
+		return err
+	}
+	printMessages(messages.Messages)
+	return nil
+}
+
+// printMessages prints a conversation with the message numbers used by fork.
+func printMessages(messages []Message) {
+	for i, message := range messages {
+		if message.Content == "" {
+			continue
+		}
+		boldBlue.Printf("[%d] %s:", i+1, strings.ToUpper(message.Role))
+		fmt.Print(message.Content + "\n\n")
+	}
+}
+
+func forkSession(args []string) error {
+	var at int
+	flags := flag.NewFlagSet("fork", flag.ContinueOnError)
+	flags.IntVar(&at, "at", 0, "Number of the last message kept in the new branch.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 || at == 0 {
+		printSessionsUsage()
+		os.Exit(-1)
+	}
+	branch := ""
+	if flags.NArg() == 2 {
+		branch = flags.Arg(1)
+	}
+
+	return updateSession(flags.Arg(0), func(m *Messages) error {
+		if err := m.fork(at, branch); err != nil {
+			return err
+		}
+		fmt.Println("switched to branch", m.activeBranch())
+		return nil
+	})
+}
+
+// updateSession loads a session, applies update and saves it again.
+func updateSession(name string, update func(m *Messages) error) error {
+	messages := NewMessages()
+	if err := messages.load(sessionPath(name)); err != nil {
+		return err
+	}
+	if err := update(messages); err != nil {
+		return err
+	}
+	return messages.save(sessionPath(name))
+}
+
+func mergeTags(tags []string, added []string) []string {
+	for _, tag := range added {
+		found := false
+		for _, t := range tags {
+			found = found || t == tag
+		}
+		if !found {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func renameSession(name, newName string) error {
+	if !isSessionName(newName) {
+		return fmt.Errorf("invalid session name: %s", newName)
+	}
+	if _, err := os.Stat(sessionPath(newName)); err == nil {
+		return fmt.Errorf("session already exists: %s", newName)
+	}
+	return os.Rename(sessionPath(name), sessionPath(newName))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+// isTerminal reports whether the file is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// openTTY opens the controlling terminal, for reading input while stdin is
+// a pipe.
+func openTTY() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.OpenFile("CONIN$", os.O_RDWR, 0)
+	}
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// terminalSize returns the width and height of stdout, 80x24 when unknown.
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// wrapText splits text into lines no wider than width display cells.
+func wrapText(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		lineWidth := 0
+		for _, word := range strings.SplitAfter(paragraph, " ") {
+			wordWidth := runewidth.StringWidth(word)
+			if lineWidth+wordWidth > width && line != "" {
+				lines = append(lines, strings.TrimRight(line, " "))
+				line, lineWidth = "", 0
+			}
+			// Words wider than a whole line are cut by display cells.
+			for wordWidth > width {
+				head := runewidth.Truncate(word, width, "")
+				if head == "" {
+					break
+				}
+				lines = append(lines, head)
+				word = word[len(head):]
+				wordWidth = runewidth.StringWidth(word)
+			}
+			line += word
+			lineWidth += wordWidth
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	return lines
+}
+
+// padRight pads s with spaces to width display cells.
+func padRight(s string, width int) string {
+	return runewidth.FillRight(s, width)
+}
+
+// printTable prints rows as aligned columns with a bold header.
+func printTable(table [][]string) {
+	widths := make([]int, len(table[0]))
+	for _, row := range table {
+		for i, cell := range row {
+			if w := runewidth.StringWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	for i, row := range table {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			cells[j] = padRight(cell, widths[j])
+		}
+		line := strings.TrimRight(strings.Join(cells, "  "), " ")
+		if i == 0 {
+			bold.Println(line)
+		} else {
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// pieceRegex splits text the way the cl100k_base pre-tokenizer does: English
+// contractions, letter runs with a leading space, numbers of up to three
+// digits, punctuation runs and whitespace.
+var pieceRegex = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// countTokens estimates the tokens of text offline. It splits text like the
+// cl100k_base pre-tokenizer and guesses from the letters of each piece how
+// many tokens it is encoded into, without the vocabulary of the encoding.
+func countTokens(text string) int {
+	tokens := 0
+	for _, piece := range pieceRegex.FindAllString(text, -1) {
+		tokens += pieceTokens(piece)
+	}
+	return tokens
+}
+
+func pieceTokens(piece string) int {
+	letters, wide := 0, 0
+	for _, r := range piece {
+		switch {
+		case isWide(r):
+			wide++
+		case unicode.IsLetter(r):
+			letters++
+		}
+	}
+	if wide > 0 {
+		// CJK characters mostly take one token, rarer ones two.
+		return wide + wide/3 + (letters+3)/4
+	}
+	if letters > 0 {
+		// Common words are a single token, long words split every few letters.
+		if letters <= 6 {
+			return 1
+		}
+		return (letters + 3) / 4
+	}
+	// Punctuation and whitespace runs merge into few tokens.
+	n := utf8.RuneCountInString(strings.TrimSpace(piece))
+	if n == 0 {
+		return 1
+	}
+	return (n + 1) / 2
+}
+
+func isWide(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// countMessagesTokens counts the tokens of a request including the few
+// tokens every message adds for its role and separators.
+func countMessagesTokens(messages []Message) int {
+	total := 3
+	for _, message := range messages {
+		total += 4 + countTokens(message.Content)
+	}
+	return total
+}
+
+// contextSizes is the context window of known models in tokens. Models are
+// matched by the longest prefix.
+var contextSizes = map[string]int{
+	"gpt-3.5-turbo":      4096,
+	"gpt-3.5-turbo-16k":  16384,
+	"gpt-3.5-turbo-1106": 16384,
+	"gpt-3.5-turbo-0125": 16384,
+	"gpt-4":              8192,
+	"gpt-4-32k":          32768,
+	"gpt-4-turbo":        128000,
+	"gpt-4-1106-preview": 128000,
+	"gpt-4-0125-preview": 128000,
+	"gpt-4o":             128000,
+	"claude":             200000,
+	"gemini":             32768,
+	"llama":              4096,
+	"mistral":            32768,
+	"qwen":               32768,
+	"deepseek":           32768,
+	"moonshot-v1-8k":     8192,
+	"moonshot-v1-32k":    32768,
+	"moonshot-v1-128k":   128000,
+	"glm-4":              128000,
+}
+
+const defaultContextSize = 4096
+
+func contextSize(model string) int {
+	best := ""
+	for prefix := range contextSizes {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return defaultContextSize
+	}
+	return contextSizes[best]
+}
+
+// replyReserve is the part of the context window kept free for the answer.
+func replyReserve(size int) int {
+	if size/4 < 1024 {
+		return size / 4
+	}
+	return 1024
+}
+
+// Context policies decide what happens when a conversation outgrows the
+// context window of its model.
+const (
+	policyKeepSystem = "keep-system" // drop the oldest turns, keep system messages
+	policyDrop       = "drop"        // drop the oldest messages, system messages included
+	policySummarize  = "summarize"   // replace the oldest turns with a summary
+	policyOff        = "off"         // send everything
+)
+
+var contextPolicy = policyKeepSystem
+
+func validContextPolicy(policy string) bool {
+	switch policy {
+	case policyKeepSystem, policyDrop, policySummarize, policyOff:
+		return true
+	}
+	return false
+}
+
+var warnColor = color.New(color.FgYellow)
+
+// fitContext trims the conversation until it fits the context window of its
+// model, following contextPolicy. The latest message is always kept.
+func fitContext(m *Messages) {
+	if contextPolicy == policyOff {
+		return
+	}
+	size := contextSize(m.Model)
+	budget := size - replyReserve(size)
+	total := countMessagesTokens(m.Messages)
+	if total <= budget {
+		return
+	}
+	// A summary needs room as well.
+	target := budget
+	if contextPolicy == policySummarize {
+		target = budget * 3 / 4
+	}
+
+	keep := make([]bool, len(m.Messages))
+	for i := range keep {
+		keep[i] = true
+	}
+	var dropped []int
+	for i := 0; i < len(m.Messages)-1 && total > target; i++ {
+		// Earlier summaries are dropped like turns, a new summary folds them in.
+		if m.Messages[i].Role == "system" && contextPolicy != policyDrop && !isSummary(m.Messages[i]) {
+			continue
+		}
+		keep[i] = false
+		dropped = append(dropped, i)
+		total -= 4 + countTokens(m.Messages[i].Content)
+		// Drop whole turns, an answer without its question confuses the model.
+		if m.Messages[i].Role == "user" && i+1 < len(m.Messages)-1 && m.Messages[i+1].Role == "assistant" {
+			i++
+			keep[i] = false
+			dropped = append(dropped, i)
+			total -= 4 + countTokens(m.Messages[i].Content)
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+
+	droppedTokens := 0
+	var droppedMessages []Message
+	for _, i := range dropped {
+		droppedTokens += 4 + countTokens(m.Messages[i].Content)
+		droppedMessages = append(droppedMessages, m.Messages[i])
+	}
+
+	var summary string
+	if contextPolicy == policySummarize {
+		summary = summarizeMessages(m.Model, droppedMessages, budget-target)
+	}
+
+	var messages []Message
+	for i, message := range m.Messages {
+		if keep[i] {
+			messages = append(messages, message)
+		}
+		// The summary takes the place of the first dropped message.
+		if summary != "" && i == dropped[0] {
+			messages = append(messages, Message{Role: "system", Content: summaryPrefix + summary})
+		}
+	}
+	m.Messages = messages
+	for _, message := range droppedMessages {
+		if !isSummary(message) {
+			m.Archive = append(m.Archive, message)
+		}
+	}
+
+	action := "dropped"
+	if summary != "" {
+		action = "summarized"
+	}
+	warnColor.Fprintf(os.Stderr, "\rcontext: %s %d oldest messages (%d tokens) to fit the %d token window of %s\n",
+		action, len(dropped), droppedTokens, size, m.Model)
+	for i, message := range droppedMessages {
+		if i == droppedPreviews {
+			fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(droppedMessages)-i)
+			break
+		}
+		content := strings.Join(strings.Fields(message.Content), " ")
+		preview := truncateLabel(content, 60)
+		if preview != content {
+			preview += "…"
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", message.Role, preview)
+	}
+}
+
+// droppedPreviews is the number of dropped messages the warning shows.
+const droppedPreviews = 5
+
+// summarizeMessages asks the model for a short summary of messages, keeping
+// the newest part when they do not fit into one request.
+func summarizeMessages(model string, messages []Message, maxTokens int) string {
+	var transcript []string
+	for _, message := range messages {
+		transcript = append(transcript, message.Role+": "+message.Content)
+	}
+
+	size := contextSize(model)
+	budget := size - replyReserve(size) - 200
+	for len(transcript) > 1 && countTokens(strings.Join(transcript, "\n")) > budget {
+		transcript = transcript[1:]
+	}
+
+	sMessages := NewMessages()
+	sMessages.Model = model
+	sMessages.Temperature = 0.1
+	sMessages.AddSystemMessage(fmt.Sprintf("Your Role: Summarize the conversation in at most %d words. Keep facts, names, decisions and open questions. Provide only the summary.", maxTokens*3/4))
+	sMessages.AddUserMessage(strings.Join(transcript, "\n"))
+	reply, err := requestData(sMessages, nil)
+	if err != nil {
+		warnColor.Fprintln(os.Stderr, "\rcontext: summary failed, dropping instead:", err)
+		return ""
+	}
+	return strings.TrimSpace(reply.Text)
+}